@@ -0,0 +1,131 @@
+package hashmap
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrLengthMismatch is returned by MSet when it is given a different number
+// of keys and values.
+var ErrLengthMismatch = errors.New("hashmap: keys and values differ in length")
+
+// batchChunk is the number of keys a batch handles per global read lock, so
+// that a large batch does not hold off resizing for long.
+const (
+	batchChunk = 256
+	batchShift = 8 // log2(batchChunk)
+)
+
+// MSet sets ks[i] to vs[i] for each i, like Set. Keys are grouped by node so
+// that each node is locked once per chunk of the batch rather than once per
+// key. If a key appears more than once, its last value wins.
+func (m *HashMap[K, V]) MSet(ks []K, vs []V) error {
+	if len(ks) != len(vs) {
+		return fmt.Errorf("%w: %d keys, %d values", ErrLengthMismatch, len(ks), len(vs))
+	}
+	hs := m.hashes(ks)
+	var added []*Entry[K, V]
+	m.batch(hs, func(t *Table[K, V], n *Node[K, V], i int) {
+		m.record(hs[i])
+		if e := m.getNodeEntry(t, n, ks[i]); e != nil {
+			m.replace(e, vs[i], 0, nil)
+			return
+		}
+		if e := m.newEntry(ks[i], vs[i], hs[i], 0); m.setNodeEntry(t, n, e, false, nil) {
+			if m.maxEntries > 0 {
+				added = append(added, e)
+			}
+		}
+	}, func() {
+		for _, e := range added {
+			m.evict(e)
+		}
+		added = added[:0]
+	})
+	return nil
+}
+
+// MGet returns the value of each key in ks, and whether it is present. It
+// is a convenience loop over Get: reads take no lock, so grouping them by
+// node would gain nothing, and the values are not read atomically together.
+func (m *HashMap[K, V]) MGet(ks []K) ([]V, []bool) {
+	vs, oks := make([]V, len(ks)), make([]bool, len(ks))
+	for i, k := range ks {
+		vs[i], oks[i] = m.get(k, m.mustHash(k))
+	}
+	return vs, oks
+}
+
+// MDel deletes the keys in ks, grouped by node like MSet, and returns how
+// many were present.
+func (m *HashMap[K, V]) MDel(ks []K) (deleted int) {
+	m.batch(m.hashes(ks), func(t *Table[K, V], n *Node[K, V], i int) {
+		if e := m.getNodeEntry(t, n, ks[i]); e != nil {
+			m.remove(t, n, e)
+			deleted++
+		}
+	}, nil)
+	return
+}
+
+// hashes hashes every key before a batch modifies the map, so that an
+// unsupported key panics without any of the batch applied.
+func (m *HashMap[K, V]) hashes(ks []K) []uint64 {
+	hs := make([]uint64, len(ks))
+	for i, k := range ks {
+		hs[i] = m.mustHash(k)
+	}
+	return hs
+}
+
+// batch calls fn with the index of each hash in hs and the node holding it
+// locked. Indexes in the same node are passed in a row under one lock, in
+// their original order. done, if not nil, is called without any lock held
+// after each chunk of batchChunk indexes.
+func (m *HashMap[K, V]) batch(hs []uint64, fn func(t *Table[K, V], n *Node[K, V], i int), done func()) {
+	// node index in the high bits and offset in the chunk in the low bits,
+	// so that sorting groups a chunk by node and keeps the original order
+	slots := make([]uint64, batchChunk)
+	for start := 0; start < len(hs); start += batchChunk {
+		m.batchStep(hs, start, slots[:min(batchChunk, len(hs)-start)], fn)
+		if done != nil {
+			done()
+		}
+	}
+}
+
+// batchStep calls fn for the chunk of hs starting at start, using chunk as
+// scratch space. The locks are released if fn panics, as it does when the
+// value it logs cannot be encoded.
+func (m *HashMap[K, V]) batchStep(hs []uint64, start int, chunk []uint64, fn func(t *Table[K, V], n *Node[K, V], i int)) {
+	m.resize()
+	m.RLock()
+	defer m.RUnlock()
+	m.migrate()
+	// hashes sharing a node in the larger table share one in both
+	capacity := m.table.Load().len()
+	if old := m.old.Load(); old != nil {
+		capacity = max(capacity, old.len())
+	}
+	for j := range chunk {
+		chunk[j] = uint64(indexOf(hs[start+j], capacity))<<batchShift | uint64(j)
+	}
+	slices.Sort(chunk)
+	var locked *Node[K, V]
+	defer func() {
+		if locked != nil {
+			locked.Unlock()
+		}
+	}()
+	for j := 0; j < len(chunk); {
+		node := chunk[j] >> batchShift
+		t, n := m.lockNode(hs[start+int(chunk[j]&(batchChunk-1))])
+		locked = n
+		for ; j < len(chunk) && chunk[j]>>batchShift == node; j++ {
+			fn(t, n, start+int(chunk[j]&(batchChunk-1)))
+		}
+		n.Unlock()
+		locked = nil
+	}
+}
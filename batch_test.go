@@ -0,0 +1,88 @@
+package hashmap
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestHashMap_MSetMismatch(t *testing.T) {
+	m := New[int, int]()
+	err := m.MSet([]int{1, 2}, []int{1})
+	if !errors.Is(err, ErrLengthMismatch) {
+		t.Fatal("expected length mismatch, got", err)
+	}
+	assertEqual(t, m.Size(), int64(0))
+	assertEqual(t, m.MSet(nil, nil), nil)
+}
+
+func TestHashMap_MSetDuplicates(t *testing.T) {
+	m := New[string, int]()
+	m.Set("a", 0)
+	assertEqual(t, m.MSet([]string{"a", "b", "a", "b"}, []int{1, 2, 3, 4}), nil)
+	assertEqual(t, m.Size(), int64(2))
+	vs, oks := m.MGet([]string{"a", "b", "c"})
+	assertEqual(t, len(vs), 3)
+	assertEqual(t, vs[0], 3)
+	assertEqual(t, vs[1], 4)
+	assertEqual(t, oks[0] && oks[1] && !oks[2], true)
+}
+
+func TestHashMap_MDel(t *testing.T) {
+	m := New[int, int]()
+	batch := 10000
+	ks := make([]int, batch)
+	for i := range ks {
+		ks[i] = i
+		m.Set(i, i)
+	}
+	assertEqual(t, m.MDel(ks[:batch/2]), batch/2)
+	assertEqual(t, m.MDel(ks), batch/2)
+	assertEqual(t, m.MDel(ks), 0)
+	assertEqual(t, m.Size(), int64(0))
+}
+
+func TestHashMap_MSetConcurrent(t *testing.T) {
+	m := New[int, int]()
+	workers, batch := 8, 20000
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(base int) {
+			defer wg.Done()
+			ks, vs := make([]int, batch), make([]int, batch)
+			for i := range ks {
+				ks[i], vs[i] = base+i, i
+			}
+			if err := m.MSet(ks, vs); err != nil {
+				t.Error(err)
+			}
+			if n := m.MDel(ks[:batch/2]); n != batch/2 {
+				t.Error("deleted", n)
+			}
+		}(w * batch)
+	}
+	wg.Wait()
+	assertEqual(t, m.Size(), int64(workers*batch/2))
+	for w := 0; w < workers; w++ {
+		for i := 0; i < batch; i++ {
+			v, ok := m.Get(w*batch + i)
+			if ok != (i >= batch/2) || ok && v != i {
+				t.Fatal("data err", w*batch+i)
+			}
+		}
+	}
+}
+
+func TestHashMap_MSetBounded(t *testing.T) {
+	m, err := NewLRU[int, int](100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := make([]int, 1000)
+	for i := range ks {
+		ks[i] = i
+	}
+	assertEqual(t, m.MSet(ks, ks), nil)
+	assertEqual(t, m.Size(), int64(100))
+}
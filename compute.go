@@ -0,0 +1,83 @@
+package hashmap
+
+// Compute atomically replaces the value of k with the result of fn, which is
+// passed the current value and whether k is present. If keep is false, k is
+// deleted, or left absent. It returns the value of k after the call and
+// whether k is present.
+//
+// fn runs with the node holding k locked, so concurrent writers of k wait
+// for it, and it must not call methods of the map itself. fn may be called
+// again if a concurrent Set, which does not take the lock to replace an
+// existing value, changes k meanwhile. An existing key keeps its
+// expiration.
+func (m *HashMap[K, V]) Compute(k K, fn func(old V, exists bool) (newV V, keep bool)) (v V, ok bool) {
+	h := m.mustHash(k)
+	m.record(h)
+	added := m.update(k, h, func(t *Table[K, V], n *Node[K, V], e *Entry[K, V]) *Entry[K, V] {
+		if e == nil {
+			var zero V
+			if v, ok = fn(zero, false); ok {
+				return m.newEntry(k, v, h, 0)
+			}
+			v = zero
+			return nil
+		}
+		// Set replaces values without the lock, retry if it did so while
+		// fn was running
+		for {
+			p := e.p.Load()
+			newV, keep := fn(*p, true)
+			if !keep {
+				if m.removeValue(t, n, e, p) {
+					return nil
+				}
+				continue
+			}
+			enc := m.encode(newV)
+			if e.p.CompareAndSwap(p, &newV) {
+				m.touch(e)
+				m.notify(OpSet, e, *p, newV, enc)
+				v, ok = newV, true
+				return nil
+			}
+		}
+	})
+	if added != nil && m.maxEntries > 0 {
+		m.evict(added)
+	}
+	return
+}
+
+// ComputeIfAbsent stores the result of fn for k if k is absent, and returns
+// the value of k. computed is true if fn was called. Like Compute, fn runs
+// with the node holding k locked.
+func (m *HashMap[K, V]) ComputeIfAbsent(k K, fn func() V) (v V, computed bool) {
+	h := m.mustHash(k)
+	m.record(h)
+	added := m.update(k, h, func(t *Table[K, V], n *Node[K, V], e *Entry[K, V]) *Entry[K, V] {
+		if e != nil {
+			m.touch(e)
+			v = e.Value()
+			return nil
+		}
+		v, computed = fn(), true
+		return m.newEntry(k, v, h, 0)
+	})
+	if added != nil && m.maxEntries > 0 {
+		m.evict(added)
+	}
+	return
+}
+
+// ComputeIfPresent replaces the value of k with the result of fn if k is
+// present, deleting k if keep is false. It returns the value of k after the
+// call and whether k is present. Like Compute, fn runs with the node holding
+// k locked.
+func (m *HashMap[K, V]) ComputeIfPresent(k K, fn func(old V) (newV V, keep bool)) (v V, ok bool) {
+	return m.Compute(k, func(old V, exists bool) (V, bool) {
+		if !exists {
+			return old, false
+		}
+		return fn(old)
+	})
+}
@@ -0,0 +1,142 @@
+package hashmap
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestHashMap_Compute(t *testing.T) {
+	m := New[string, int]()
+	v, ok := m.Compute("a", func(old int, exists bool) (int, bool) {
+		assertEqual(t, exists, false)
+		return 1, true
+	})
+	assertEqual(t, v, 1)
+	assertEqual(t, ok, true)
+	v, ok = m.Compute("a", func(old int, exists bool) (int, bool) {
+		assertEqual(t, exists, true)
+		return old + 1, true
+	})
+	assertEqual(t, v, 2)
+	assertEqual(t, ok, true)
+	v, ok = m.Compute("a", func(old int, exists bool) (int, bool) {
+		return old, false
+	})
+	assertEqual(t, ok, false)
+	assertEqual(t, v, 0)
+	_, ok = m.Get("a")
+	assertEqual(t, ok, false)
+	_, ok = m.Compute("b", func(old int, exists bool) (int, bool) {
+		return 1, false
+	})
+	assertEqual(t, ok, false)
+	assertEqual(t, m.Size(), int64(0))
+}
+
+func TestHashMap_ComputeIfAbsent(t *testing.T) {
+	m := New[string, int]()
+	v, computed := m.ComputeIfAbsent("a", func() int { return 1 })
+	assertEqual(t, v, 1)
+	assertEqual(t, computed, true)
+	v, computed = m.ComputeIfAbsent("a", func() int {
+		t.Fatal("computed a present key")
+		return 2
+	})
+	assertEqual(t, v, 1)
+	assertEqual(t, computed, false)
+}
+
+func TestHashMap_ComputeIfPresent(t *testing.T) {
+	m := New[string, int]()
+	_, ok := m.ComputeIfPresent("a", func(old int) (int, bool) {
+		t.Fatal("computed an absent key")
+		return 0, true
+	})
+	assertEqual(t, ok, false)
+	assertEqual(t, m.Size(), int64(0))
+	m.Set("a", 1)
+	v, ok := m.ComputeIfPresent("a", func(old int) (int, bool) {
+		return old * 10, true
+	})
+	assertEqual(t, v, 10)
+	assertEqual(t, ok, true)
+	_, ok = m.ComputeIfPresent("a", func(old int) (int, bool) {
+		return 0, false
+	})
+	assertEqual(t, ok, false)
+	assertEqual(t, m.Size(), int64(0))
+}
+
+func TestHashMap_ComputeConcurrent(t *testing.T) {
+	m := New[int, int]()
+	workers, keys, rounds := 8, 1000, 20
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				for k := 0; k < keys; k++ {
+					m.Compute(k, func(old int, exists bool) (int, bool) {
+						return old + 1, true
+					})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assertEqual(t, m.Size(), int64(keys))
+	for k := 0; k < keys; k++ {
+		v, _ := m.Get(k)
+		if v != workers*rounds {
+			t.Fatal("lost update", k, v)
+		}
+	}
+}
+
+func TestHashMap_ComputeDeleteRace(t *testing.T) {
+	m := New[string, int]()
+	m.Set("k", 10)
+	seen, set := make(chan struct{}), make(chan struct{})
+	calls := 0
+	go func() {
+		<-seen
+		assertEqual(t, m.Set("k", 11), 10)
+		close(set)
+	}()
+	// fn deletes 10 only, so it has to see the value set while it ran
+	v, ok := m.Compute("k", func(old int, exists bool) (int, bool) {
+		if calls++; calls == 1 {
+			close(seen)
+			<-set
+		}
+		return old, old != 10
+	})
+	assertEqual(t, calls, 2)
+	assertEqual(t, v, 11)
+	assertEqual(t, ok, true)
+	v, _ = m.Get("k")
+	assertEqual(t, v, 11)
+
+	// a Set racing the delete is either seen by fn or applied after it
+	for i := 0; i < 10000; i++ {
+		m.Set("k", 10)
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.Compute("k", func(old int, exists bool) (int, bool) {
+				return old, old != 10
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			m.Set("k", 11)
+		}()
+		wg.Wait()
+		if v, ok := m.Get("k"); !ok || v != 11 {
+			t.Fatal("lost write", i, v, ok)
+		}
+		assertEqual(t, m.Size(), int64(1))
+	}
+}
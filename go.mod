@@ -1,3 +1,3 @@
-module github.com/awesome-cap/hashmap
+module github.com/awesome-cap/hashmap/v2
 
-go 1.15
+go 1.24
@@ -1,66 +1,149 @@
 package hashmap
 
 import (
-	"encoding/json"
 	"fmt"
+	"hash/maphash"
 	"math"
+	"math/bits"
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
-	"unsafe"
 )
 
 const MaxInt = 2147483647
 
-type HashMap struct {
+// maxCapacity is the largest number of nodes of a table, the largest power of
+// two not over MaxInt.
+const maxCapacity = 1 << 30
+
+const (
+	defaultCapacity   = 16
+	defaultLoadFactor = 0.7 * 3
+)
+
+type HashMap[K comparable, V any] struct {
 	sync.RWMutex
 
 	size       int64
-	table      *Table
+	table      atomic.Pointer[Table[K, V]]
+	old        atomic.Pointer[Table[K, V]] // table being migrated into table, nil when not resizing
+	epoch      uint64                      // incremented each time a resize starts
 	loadFactor float64
+	lowWater   float64 // shrink below this many entries per node, 0 never shrinks
+	step       int     // nodes each write migrates, see setLowWater
+	minCap     int
+	maxCap     int
+	hasher     func(K) uint64
+	seed       *maphash.Seed
+	checkKey   bool
+	janitor    janitor
+	maxEntries int64 // 0 if unbounded
+	onEvict    func(K, V)
+	sketch     *sketch // access frequencies, only kept for EvictTinyLFU
+	tombstones int64   // entries removed by LogicDel but still linked
+	purge      bool    // whether the janitor unlinks tombstones
+	snapMu     sync.Mutex
+	snapGen    uint64
+	snap       atomic.Pointer[snapshot[K, V]] // snapshot being taken, nil if none
+	txns       int32                          // transactions running
+	watchMu    sync.Mutex
+	watchers   atomic.Pointer[watchers[K, V]] // nil if none
+	watchBuf   int                            // events buffered per watcher
+	onFull     WatchPolicy                    // what happens to a watcher whose buffer is full
+	codec      Codec[V]                       // encodes values for SaveTo and LoadFrom
+	oplog      *opLog                         // append-only log of the writes, nil if none
+	jsonKey    reflect.Type                   // type of the keys of the plain JSON format
+	typedJSON  bool
 }
 
-type Table struct {
-	nodes []*Node
+type Table[K comparable, V any] struct {
+	nodes []Node[K, V]
 	ab    int
+
+	// migration progress while this is the old table of a resize
+	cursor int64 // next node to migrate
+	moved  int64 // nodes migrated
 }
 
-type Node struct {
+type Node[K comparable, V any] struct {
 	sync.Mutex
 
-	head *Entry
-	tail *Entry
-	size int64
+	head  atomic.Pointer[Entry[K, V]]
+	tail  *Entry[K, V]
+	size  int64
+	moved int32  // 1 once the node has been migrated to the new table
+	snap  uint64 // generation of the last snapshot the node was copied into
 }
 
-type Entry struct {
-	k    interface{}
-	p    unsafe.Pointer
-	hash uint64
-	flag int32 // 1 deleted
-	next []*Entry
-	prev []*Entry
+type Entry[K comparable, V any] struct {
+	k      K
+	p      atomic.Pointer[V]
+	hash   uint64
+	flag   int32 // 1 deleted
+	expire int64 // expiration time as returned by now, 0 if the entry never expires
+	access int64 // last access as returned by now, only kept when the map is bounded
+	next   [2]atomic.Pointer[Entry[K, V]]
+	prev   [2]*Entry[K, V]
 }
 
-func New() *HashMap {
-	return &HashMap{
-		table: &Table{
-			nodes: allocate(16),
-			ab:    0,
-		},
-		loadFactor: 0.7 * 3,
-	}
+// AnyMap is the untyped map, equivalent to the HashMap of earlier releases.
+type AnyMap = HashMap[any, any]
+
+func New[K comparable, V any]() *HashMap[K, V] {
+	return newHashMap[K, V](defaultCapacity, defaultLoadFactor, maxCapacity)
 }
 
-func allocate(capacity int) (nodes []*Node) {
-	nodes = make([]*Node, capacity)
-	for i := 0; i < capacity; i++ {
-		nodes[i] = &Node{}
-	}
-	return
+func newHashMap[K comparable, V any](capacity int, loadFactor float64, maxCap int) *HashMap[K, V] {
+	m := &HashMap[K, V]{}
+	m.init(capacity, loadFactor, maxCap)
+	return m
+}
+
+// init sets up the zero map m.
+func (m *HashMap[K, V]) init(capacity int, loadFactor float64, maxCap int) {
+	m.loadFactor = loadFactor
+	m.setLowWater(loadFactor / 4)
+	m.minCap, m.maxCap = capacity, maxCap
+	m.checkKey = mayPanicOnCompare(reflect.TypeFor[K]())
+	m.janitor.interval = defaultJanitorInterval
+	m.watchBuf = defaultWatchBuffer
+	m.codec = GobCodec[V]{}
+	m.jsonKey = jsonKeyType[K]()
+	m.table.Store(&Table[K, V]{
+		nodes: allocate[K, V](capacity),
+		ab:    0,
+	})
 }
 
-func hash(k interface{}) uint64 {
+// NewAny returns an untyped map accepting keys and values of any supported type.
+func NewAny() *AnyMap {
+	return New[any, any]()
+}
+
+// allocate makes the nodes of a table in a single allocation, keeping the
+// cost of starting a resize small.
+func allocate[K comparable, V any](capacity int) []Node[K, V] {
+	return make([]Node[K, V], capacity)
+}
+
+func (m *HashMap[K, V]) newEntry(k K, v V, h uint64, expire int64) *Entry[K, V] {
+	e := &Entry[K, V]{k: k, hash: h, expire: expire}
+	e.p.Store(&v)
+	m.touch(e)
+	return e
+}
+
+// Hasher is implemented by key types that compute their own hash, such as
+// composite struct keys.
+type Hasher interface {
+	HashKey() uint64
+}
+
+// keySeed seeds the fallback hash for comparable keys of other types.
+var keySeed = maphash.MakeSeed()
+
+func hash(k any) uint64 {
 	if k == nil {
 		return 0
 	}
@@ -73,288 +156,773 @@ func hash(k interface{}) uint64 {
 		if x {
 			return 0
 		} else {
-			return 1
+			return mix(1)
 		}
 	case time.Time:
-		return uint64(x.UnixNano())
+		return mix(uint64(x.UnixNano()))
 	case int:
-		return uint64(x)
+		return mix(uint64(x))
 	case int8:
-		return uint64(x)
+		return mix(uint64(x))
 	case int16:
-		return uint64(x)
+		return mix(uint64(x))
 	case int32:
-		return uint64(x)
+		return mix(uint64(x))
 	case int64:
-		return uint64(x)
+		return mix(uint64(x))
 	case uint:
-		return uint64(x)
+		return mix(uint64(x))
 	case uint8:
-		return uint64(x)
+		return mix(uint64(x))
 	case uint16:
-		return uint64(x)
+		return mix(uint64(x))
 	case uint32:
-		return uint64(x)
+		return mix(uint64(x))
 	case uint64:
-		return x
+		return mix(x)
 	case float32:
-		return math.Float64bits(float64(x))
+		return mix(math.Float64bits(float64(x)))
 	case float64:
-		return math.Float64bits(x)
+		return mix(math.Float64bits(x))
 	case uintptr:
-		return uint64(x)
+		return mix(uint64(x))
+	case Hasher:
+		return x.HashKey()
+	}
+	return maphash.Comparable(keySeed, k)
+}
+
+func (m *HashMap[K, V]) hash(k K) (uint64, error) {
+	if m.checkKey {
+		if err := checkKey(k); err != nil {
+			return 0, err
+		}
+		// other key types are checked when the log is opened
+		if x := any(k); m.oplog != nil && x != nil {
+			if _, ok := keyTag(reflect.TypeOf(x)); !ok {
+				return 0, fmt.Errorf("%w: %T cannot be logged", ErrUnsupportedKey, x)
+			}
+		}
 	}
-	panic("unsupported key type.")
+	if m.hasher != nil {
+		return m.hasher(k), nil
+	}
+	if m.seed != nil {
+		if s, ok := any(k).(string); ok {
+			return maphash.String(*m.seed, s), nil
+		}
+	}
+	return hash(k), nil
+}
+
+func (m *HashMap[K, V]) mustHash(k K) uint64 {
+	h, err := m.hash(k)
+	if err != nil {
+		panic(err)
+	}
+	return h
 }
 
+// bytesHash is the 64-bit FNV-1a hash of bytes, finalized with mix so that
+// the low bits used by indexOf depend on every input byte.
 func bytesHash(bytes []byte) uint64 {
-	hash := uint32(2166136261)
-	const prime32 = uint32(16777619)
+	hash := uint64(14695981039346656037)
+	const prime64 = uint64(1099511628211)
 	keyLength := len(bytes)
 	for i := 0; i < keyLength; i++ {
-		hash *= prime32
-		hash ^= uint32(bytes[i])
+		hash ^= uint64(bytes[i])
+		hash *= prime64
 	}
-	return uint64(hash)
+	return mix(hash)
+}
+
+// mix is the murmur3 64-bit finalizer. It spreads sequential and strided
+// integers evenly over the low bits used to pick a bucket.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
 }
 
 func indexOf(hash uint64, capacity int) int {
 	return int(hash & uint64(capacity-1))
 }
 
-func (t *Table) len() int {
+func (t *Table[K, V]) len() int {
 	return len(t.nodes)
 }
 
-func (m *HashMap) Size() int64 {
-	return m.size
+func (m *HashMap[K, V]) Size() int64 {
+	return atomic.LoadInt64(&m.size)
 }
 
 //Set will CAS the existing value if k exists. If k is new, this function is locked and set node's head
 //Similar to Java's hashmap's Put
 //returns old value if k previously exists
-//returns nil if k is new
-func (m *HashMap) Set(k interface{}, v interface{}) interface{} {
+//returns the zero value of V if k is new
+func (m *HashMap[K, V]) Set(k K, v V) V {
+	return m.set(k, m.mustHash(k), v, 0)
+}
+
+func (m *HashMap[K, V]) set(k K, h uint64, v V, expire int64) V {
+	m.record(h)
+	old, added := m.put(k, h, v, expire)
+	if added != nil && m.maxEntries > 0 {
+		m.evict(added)
+	}
+	return old
+}
+
+// put sets k and returns its previous value, and the new entry if k was
+// inserted.
+func (m *HashMap[K, V]) put(k K, h uint64, v V, expire int64) (old V, added *Entry[K, V]) {
 	m.resize()
 	m.RLock()
 	defer m.RUnlock()
-
-	h, t := hash(k), m.table
-	n := t.nodes[indexOf(h, t.len())]
-
-	//If key exists
+	m.migrate()
+
+	//If key exists, unless a snapshot or transaction needs the node locked.
+	//If e was flagged meanwhile, v may have gone with it, so set k again
+	//under the lock
+	var e *Entry[K, V]
+	if !m.mustLock() {
+		if _, e = m.getEntry(k, h); e != nil {
+			if old = m.replace(e, v, expire, nil); atomic.LoadInt32(&e.flag) == 0 {
+				return old, nil
+			}
+		}
+	}
+	t, n := m.lockNode(h)
+	defer n.Unlock()
+	if e != nil {
+		//Compute flags e while it checks the value, and unflags it if v came in
+		if atomic.LoadInt32(&e.flag) == 0 {
+			return old, nil
+		}
+		var zero V
+		old = zero
+	}
 	if e := m.getNodeEntry(t, n, k); e != nil {
-		oldValue := e.Value()
-		atomic.StorePointer(&e.p, unsafe.Pointer(&v))
-		return oldValue
+		return m.replace(e, v, expire, nil), nil
 	}
-	n.Lock()
-	if m.setNodeEntry(t, n, &Entry{k: k, p: unsafe.Pointer(&v), hash: h, next: make([]*Entry, 2), prev: make([]*Entry, 2)}, false) {
-		atomic.AddInt64(&n.size, 1)
-		atomic.AddInt64(&m.size, 1)
+	if e := m.newEntry(k, v, h, expire); m.setNodeEntry(t, n, e, false, nil) {
+		added = e
 	}
-	n.Unlock()
-	return nil
+	return
 }
 
-func (m *HashMap) MSet(ks []interface{}, vs []interface{}) {
-	if len(ks) != len(vs) {
-		return
+// replace stores v and expire in e and returns its previous value. enc is v
+// encoded by encode, or nil to encode it first.
+func (m *HashMap[K, V]) replace(e *Entry[K, V], v V, expire int64, enc []byte) V {
+	if enc == nil {
+		enc = m.encode(v)
 	}
-	for i, k := range ks {
-		m.Set(k, vs[i])
+	if expire != 0 || atomic.LoadInt64(&e.expire) != 0 {
+		atomic.StoreInt64(&e.expire, expire)
 	}
+	m.touch(e)
+	old := *e.p.Swap(&v)
+	m.notify(OpSet, e, old, v, enc)
+	return old
 }
 
-func (m *HashMap) SetNX(k interface{}, v interface{}) bool {
+func (m *HashMap[K, V]) SetNX(k K, v V) bool {
+	return m.setNX(k, m.mustHash(k), v)
+}
+
+func (m *HashMap[K, V]) setNX(k K, h uint64, v V) bool {
+	m.record(h)
+	added := m.putNX(k, h, v)
+	if added != nil && m.maxEntries > 0 {
+		m.evict(added)
+	}
+	return added != nil
+}
+
+// putNX inserts k if it is absent and returns the new entry, or nil if k
+// was present.
+func (m *HashMap[K, V]) putNX(k K, h uint64, v V) *Entry[K, V] {
 	m.resize()
 	m.RLock()
 	defer m.RUnlock()
-	t := m.table
-	n, h := t.getKeyNode(k)
-	n.Lock()
+	m.migrate()
+	t, n := m.lockNode(h)
 	defer n.Unlock()
-	return m.setNodeEntry(t, n, &Entry{k: k, p: unsafe.Pointer(&v), hash: h, next: make([]*Entry, 2), prev: make([]*Entry, 2)}, true)
+	if e := m.newEntry(k, v, h, 0); m.setNodeEntry(t, n, e, true, nil) {
+		return e
+	}
+	return nil
 }
 
-func (t *Table) getKeyNode(k interface{}) (*Node, uint64) {
-	h, nodes := hash(k), t.nodes
-	i := indexOf(h, len(nodes))
-	return nodes[i], h
+// update calls fn with the node holding h locked and the live entry for k,
+// or nil if k is absent. fn may change or remove that entry, or return a new
+// entry to insert, which is then returned so that the caller can evict once
+// the lock is released.
+func (m *HashMap[K, V]) update(k K, h uint64, fn func(t *Table[K, V], n *Node[K, V], e *Entry[K, V]) *Entry[K, V]) (added *Entry[K, V]) {
+	m.resize()
+	m.RLock()
+	defer m.RUnlock()
+	m.migrate()
+	t, n := m.lockNode(h)
+	defer n.Unlock()
+	if e := fn(t, n, m.getNodeEntry(t, n, k)); e != nil && m.setNodeEntry(t, n, e, true, nil) {
+		return e
+	}
+	return nil
 }
 
-func (m *HashMap) setNodeEntry(t *Table, n *Node, e *Entry, nx bool) bool {
-	if n.head == nil {
-		n.head, n.tail = e, e
-	} else {
-		next := n.head
-		for next != nil {
-			if next.k == e.k {
+func (t *Table[K, V]) getNode(h uint64) *Node[K, V] {
+	return &t.nodes[indexOf(h, len(t.nodes))]
+}
+
+// lockNode locks and returns the node holding the bucket of h: the old
+// table's node until it has been migrated, the new table's node after.
+// The caller must hold the read lock.
+func (m *HashMap[K, V]) lockNode(h uint64) (*Table[K, V], *Node[K, V]) {
+	if old := m.old.Load(); old != nil {
+		n := old.getNode(h)
+		n.Lock()
+		if atomic.LoadInt32(&n.moved) == 0 {
+			m.preserve(old, n)
+			return old, n
+		}
+		n.Unlock()
+	}
+	t := m.table.Load()
+	n := t.getNode(h)
+	n.Lock()
+	m.preserve(t, n)
+	return t, n
+}
+
+// setNodeEntry links e into n, counting it in the sizes, or sets its value
+// and expiration in the live entry of its key unless nx is true, and reports
+// whether e was linked. enc is the value of e encoded by encode, or nil to
+// encode it first. The caller must hold n's lock.
+func (m *HashMap[K, V]) setNodeEntry(t *Table[K, V], n *Node[K, V], e *Entry[K, V], nx bool, enc []byte) bool {
+	var dead *Entry[K, V]
+	for next := n.head.Load(); next != nil; next = next.next[t.ab].Load() {
+		if next.k == e.k {
+			if atomic.LoadInt32(&next.flag) == 0 && !next.expired() {
 				if !nx {
-					next.p = e.p
+					m.replace(next, e.Value(), e.expire, enc)
 				}
 				return false
 			}
-			next = next.next[t.ab]
+			dead = next
+			break
 		}
-		n.tail.next[t.ab], e.prev[t.ab], n.tail = e, n.tail, e
 	}
+	if enc == nil {
+		enc = m.encode(e.Value())
+	}
+	if dead != nil {
+		// logically deleted, or expired but not reclaimed by the janitor
+		// yet, replace it
+		m.remove(t, n, dead)
+	}
+	if n.head.Load() == nil {
+		n.head.Store(e)
+	} else {
+		e.prev[t.ab] = n.tail
+		n.tail.next[t.ab].Store(e)
+	}
+	n.tail = e
+	atomic.AddInt64(&n.size, 1)
+	atomic.AddInt64(&m.size, 1)
+	var zero V
+	m.notify(OpSet, e, zero, e.Value(), enc)
 	return true
 }
 
-func (m *HashMap) dilate() bool {
-	return m.size > int64(float64(m.table.len())*m.loadFactor) && m.table.len()*2 <= MaxInt
+func (m *HashMap[K, V]) dilate() bool {
+	capacity := m.table.Load().len()
+	return atomic.LoadInt64(&m.size) > int64(float64(capacity)*m.loadFactor) && capacity*2 <= m.maxCap
+}
+
+// contract reports whether the table has fallen below its low-water mark.
+// The mark is at most half the load factor, so a halved table is still
+// under the load factor and the map does not thrash between sizes.
+//
+// A shrink waits for the migration in progress to finish: deletes can bring
+// the next mark closer than the migration needs writes to end, and starting
+// the shrink would migrate the rest under the write lock.
+func (m *HashMap[K, V]) contract() bool {
+	capacity := m.table.Load().len()
+	return capacity > m.minCap && atomic.LoadInt64(&m.size) < int64(float64(capacity)*m.lowWater) &&
+		m.old.Load() == nil
 }
 
-func (m *HashMap) resize() {
-	if m.dilate() {
+// resize starts a new migration once the table is over its load factor or
+// under its low-water mark, and drops the old table once a migration has
+// finished. Entries are moved to the new table a few nodes at a time by
+// migrate, so no single operation pays for rehashing the whole map.
+func (m *HashMap[K, V]) resize() {
+	if old := m.old.Load(); old != nil && atomic.LoadInt64(&old.moved) == int64(old.len()) {
+		m.old.CompareAndSwap(old, nil)
+	}
+	if (m.dilate() || m.contract()) && !m.snapshotting() {
 		m.Lock()
 		defer m.Unlock()
+		if m.snapshotting() {
+			return
+		}
 		if m.dilate() {
-			m.doResize()
+			m.startResize(m.table.Load().len() * 2)
+		} else if m.contract() {
+			m.startResize(m.table.Load().len() / 2)
 		}
 	}
 }
 
-func (m *HashMap) doResize() {
-	oldTable := m.table
-	newTable := &Table{nodes: allocate(oldTable.len() * 2), ab: m.table.ab ^ 1}
-	capacity := newTable.len()
-	size := int64(0)
-	for _, node := range oldTable.nodes {
-		next := node.head
-		for next != nil {
-			next.next[newTable.ab], next.prev[newTable.ab] = nil, nil
-			newNode := newTable.nodes[indexOf(next.hash, capacity)]
-			if newNode.head == nil {
-				newNode.head, newNode.tail = next, next
-			} else {
-				newNode.tail.next[newTable.ab], next.prev[newTable.ab], newNode.tail = next, newNode.tail, next
+// startResize replaces the table with one of the given capacity, linked
+// through the other half of each entry's next/prev pair. The caller must
+// hold the write lock.
+//
+// That half still holds the links of the table before the old one, which
+// a lock-free reader that started before the previous resize may be
+// walking. Bumping the epoch first lets such a reader detect that its
+// chain may have been relinked under it, see getEntry.
+func (m *HashMap[K, V]) startResize(capacity int) {
+	m.finishResize()
+	old := m.table.Load()
+	atomic.AddUint64(&m.epoch, 1)
+	m.old.Store(old)
+	m.table.Store(&Table[K, V]{nodes: allocate[K, V](capacity), ab: old.ab ^ 1})
+}
+
+// finishResize migrates whatever is left of the old table and drops it.
+// The caller must hold the write lock.
+func (m *HashMap[K, V]) finishResize() {
+	if old := m.old.Load(); old != nil {
+		for i := range old.nodes {
+			if node := &old.nodes[i]; atomic.LoadInt32(&node.moved) == 0 {
+				m.migrateNode(old, node)
 			}
-			size++
-			newNode.size++
-			next = next.next[oldTable.ab]
 		}
+		m.old.Store(nil)
+	}
+}
+
+// Compact shrinks the table to the smallest capacity that holds the current
+// entries at half the load factor. Unlike the automatic shrinking done by
+// Del it migrates every entry before returning, so it blocks other
+// operations for the duration; call it after a purge of many keys.
+func (m *HashMap[K, V]) Compact() {
+	m.snapMu.Lock()
+	defer m.snapMu.Unlock()
+	m.Lock()
+	defer m.Unlock()
+	m.finishResize()
+	capacity, current := m.minCap, m.table.Load().len()
+	for float64(m.size) > float64(capacity)*m.loadFactor/2 && capacity < current {
+		capacity *= 2
+	}
+	if capacity < current {
+		m.startResize(capacity)
+		m.finishResize()
+	}
+}
+
+// migrateStep is the least number of nodes each write moves to the new
+// table. A table of n nodes grows after at least n more insertions, so
+// migrating at least one node per write finishes every growth before the
+// next begins.
+const migrateStep = 2
+
+// setLowWater sets the low-water mark and the migration step that goes with
+// it. A table of n nodes halved at lw entries per node is halved again after
+// n*lw/2 more deletions, so each write moves 2/lw nodes to finish the
+// migration in time.
+func (m *HashMap[K, V]) setLowWater(lw float64) {
+	m.lowWater, m.step = lw, migrateStep
+	if lw > 0 {
+		m.step = max(m.step, int(math.Ceil(2/lw)))
+	}
+}
+
+// migrate moves up to m.step nodes of the old table into the new one.
+// The caller must hold the read lock.
+func (m *HashMap[K, V]) migrate() {
+	old := m.old.Load()
+	if old == nil || m.snapshotting() {
+		return
+	}
+	for i := 0; i < m.step; i++ {
+		idx := atomic.AddInt64(&old.cursor, 1) - 1
+		if idx >= int64(old.len()) {
+			return
+		}
+		m.migrateNode(old, &old.nodes[idx])
+	}
+}
+
+// migrateNode relinks the entries of node n of the old table into the new
+// table. The old links are left untouched so that readers already walking
+// the old chain still reach its end.
+func (m *HashMap[K, V]) migrateNode(old *Table[K, V], n *Node[K, V]) {
+	t := m.table.Load()
+	capacity := t.len()
+	n.Lock()
+	next := n.head.Load()
+	for next != nil {
+		next.next[t.ab].Store(nil)
+		next.prev[t.ab] = nil
+		newNode := &t.nodes[indexOf(next.hash, capacity)]
+		newNode.Lock()
+		if newNode.head.Load() == nil {
+			newNode.head.Store(next)
+		} else {
+			next.prev[t.ab] = newNode.tail
+			newNode.tail.next[t.ab].Store(next)
+		}
+		newNode.tail = next
+		atomic.AddInt64(&newNode.size, 1)
+		newNode.Unlock()
+		next = next.next[old.ab].Load()
 	}
-	m.size = size
-	m.table = newTable
+	atomic.StoreInt32(&n.moved, 1)
+	n.Unlock()
+	atomic.AddInt64(&old.moved, 1)
 }
 
-func (m *HashMap) getNodeEntry(t *Table, n *Node, k interface{}) *Entry {
-	next := n.head
+func (m *HashMap[K, V]) getNodeEntry(t *Table[K, V], n *Node[K, V], k K) *Entry[K, V] {
+	next := n.head.Load()
 	for next != nil {
-		if next.k == k && next.flag == 0 {
+		if next.k == k && atomic.LoadInt32(&next.flag) == 0 && !next.expired() {
 			return next
 		}
-		next = next.next[t.ab]
+		next = next.next[t.ab].Load()
 	}
 	return nil
 }
 
-func (m *HashMap) Get(k interface{}) (interface{}, bool) {
-	t := m.table
-	n, _ := t.getKeyNode(k)
-	e := m.getNodeEntry(t, n, k)
-	if e != nil {
+// getEntry looks k up without locking. A miss is only reported if no resize
+// started during the lookup; otherwise the chain walked may have been
+// relinked into another table and the lookup is retried.
+func (m *HashMap[K, V]) getEntry(k K, h uint64) (*Node[K, V], *Entry[K, V]) {
+	for {
+		epoch := atomic.LoadUint64(&m.epoch)
+		n, e := m.findEntry(k, h)
+		if e != nil || atomic.LoadUint64(&m.epoch) == epoch {
+			return n, e
+		}
+	}
+}
+
+// lookup looks k up for a reader. While a transaction runs, it reads under
+// the node lock, which the transaction holds until its commit is complete,
+// so that a commit is never observed partially.
+func (m *HashMap[K, V]) lookup(k K, h uint64) *Entry[K, V] {
+	if atomic.LoadInt32(&m.txns) == 0 {
+		_, e := m.getEntry(k, h)
+		return e
+	}
+	m.RLock()
+	defer m.RUnlock()
+	t, n := m.lockNode(h)
+	defer n.Unlock()
+	return m.getNodeEntry(t, n, k)
+}
+
+// findEntry looks k up in the node currently holding its bucket.
+func (m *HashMap[K, V]) findEntry(k K, h uint64) (*Node[K, V], *Entry[K, V]) {
+	t, n := m.chain(h)
+	return n, m.getNodeEntry(t, n, k)
+}
+
+// chain returns the node holding the bucket of h without locking it: the
+// old table's node until it has been migrated, the new table's node after.
+func (m *HashMap[K, V]) chain(h uint64) (*Table[K, V], *Node[K, V]) {
+	if old := m.old.Load(); old != nil {
+		if n := old.getNode(h); atomic.LoadInt32(&n.moved) == 0 {
+			return old, n
+		}
+	}
+	t := m.table.Load()
+	return t, t.getNode(h)
+}
+
+func (m *HashMap[K, V]) Get(k K) (V, bool) {
+	return m.get(k, m.mustHash(k))
+}
+
+func (m *HashMap[K, V]) get(k K, h uint64) (V, bool) {
+	if e := m.lookup(k, h); e != nil {
+		m.record(h)
+		m.touch(e)
 		return e.Value(), true
 	}
-	return nil, false
+	var zero V
+	return zero, false
+}
+
+func (m *HashMap[K, V]) Del(k K) bool {
+	return m.del(k, m.mustHash(k))
 }
 
-func (m *HashMap) Del(k interface{}) bool {
+func (m *HashMap[K, V]) del(k K, h uint64) bool {
+	m.resize()
 	m.RLock()
 	defer m.RUnlock()
+	m.migrate()
 
-	t := m.table
-	n, _ := t.getKeyNode(k)
-	n.Lock()
+	t, n := m.lockNode(h)
 	defer n.Unlock()
 	if e := m.getNodeEntry(t, n, k); e != nil {
-		if e.prev[t.ab] == nil && e.next[t.ab] == nil {
-			n.head, n.tail = nil, nil
-		} else if e.prev[t.ab] == nil {
-			n.head = e.next[t.ab]
-			n.head.prev[t.ab] = nil
-		} else if e.next[t.ab] == nil {
-			n.tail = e.prev[t.ab]
-			n.tail.next[t.ab] = nil
-		} else {
-			e.prev[t.ab].next[t.ab] = e.next[t.ab]
-			e.next[t.ab].prev[t.ab] = e.prev[t.ab]
-		}
-		oldAb := t.ab ^ 1
-		if e.prev[oldAb] != nil {
-			e.prev[oldAb].next[oldAb] = e.next[oldAb]
-		}
-		if e.next[oldAb] != nil {
-			e.next[oldAb].prev[oldAb] = e.prev[oldAb]
-		}
-		atomic.AddInt64(&n.size, -1)
-		atomic.AddInt64(&m.size, -1)
+		m.remove(t, n, e)
 		return true
 	}
 	return false
 }
 
-func (m *HashMap) LogicDel(k interface{}) bool {
-	h, t := hash(k), m.table
-	n := t.nodes[indexOf(h, t.len())]
+// remove unlinks e, which is either live or a tombstone left by LogicDel,
+// from n and accounts for it in the sizes. The caller must hold n's lock.
+func (m *HashMap[K, V]) remove(t *Table[K, V], n *Node[K, V], e *Entry[K, V]) {
+	// swap the flag so that a concurrent LogicDel of e is counted once
+	m.drop(t, n, e, atomic.SwapInt32(&e.flag, 1) == 0)
+}
+
+// removeValue removes e like remove if its value is still p, reporting
+// whether it did. It flags e before checking p again, so that a Set
+// replacing p without the lock either shows here or finds e flagged and
+// waits for the lock, which then finds e live again.
+func (m *HashMap[K, V]) removeValue(t *Table[K, V], n *Node[K, V], e *Entry[K, V], p *V) bool {
+	if e.p.Load() != p {
+		return false
+	}
+	live := atomic.CompareAndSwapInt32(&e.flag, 0, 1)
+	if live && e.p.Load() != p {
+		atomic.StoreInt32(&e.flag, 0)
+		return false
+	}
+	m.drop(t, n, e, live)
+	return true
+}
+
+// drop is remove for an entry the caller flagged itself, live reporting
+// whether it was live rather than a tombstone.
+func (m *HashMap[K, V]) drop(t *Table[K, V], n *Node[K, V], e *Entry[K, V], live bool) {
+	t.unlink(n, e)
+	if !live {
+		atomic.AddInt64(&m.tombstones, -1)
+		return
+	}
+	atomic.AddInt64(&n.size, -1)
+	atomic.AddInt64(&m.size, -1)
+	op := OpDel
+	if e.expired() {
+		op = OpExpire
+	}
+	var zero V
+	m.notify(op, e, e.Value(), zero, nil)
+}
+
+// unlink removes e from the chain of n. The caller must hold n's lock.
+// e's own next link is kept so that readers standing on it can go on.
+func (t *Table[K, V]) unlink(n *Node[K, V], e *Entry[K, V]) {
+	prev, next := e.prev[t.ab], e.next[t.ab].Load()
+	if prev == nil {
+		n.head.Store(next)
+	} else {
+		prev.next[t.ab].Store(next)
+	}
+	if next == nil {
+		n.tail = prev
+	} else {
+		next.prev[t.ab] = prev
+	}
+	// a migrated entry is still linked into the old chain, which is no
+	// longer modified; flag it so readers of that chain skip it
+	atomic.StoreInt32(&e.flag, 1)
+}
+
+// LogicDel deletes k by flagging its entry without taking the node lock. The
+// entry stays linked as a tombstone until k is set again, Purge is called or
+// the janitor unlinks it under WithTombstonePurge.
+func (m *HashMap[K, V]) LogicDel(k K) bool {
+	return m.logicDel(k, m.mustHash(k))
+}
 
+func (m *HashMap[K, V]) logicDel(k K, h uint64) bool {
+	m.RLock()
+	defer m.RUnlock()
+	if m.mustLock() {
+		t, n := m.lockNode(h)
+		defer n.Unlock()
+		e := m.getNodeEntry(t, n, k)
+		return e != nil && m.bury(n, e)
+	}
 	//If key exists
-	if e := m.getNodeEntry(t, n, k); e != nil {
-		if atomic.CompareAndSwapInt32(&e.flag, 0, 1) {
-			atomic.AddInt64(&n.size, -1)
-			atomic.AddInt64(&m.size, -1)
-			return true
-		}
+	if n, e := m.getEntry(k, h); e != nil {
+		return m.bury(n, e)
 	}
 	return false
 }
 
-func (e *Entry) Value() interface{} {
-	return *(*interface{})(e.p)
+// bury turns e into a tombstone, reporting whether it was live.
+func (m *HashMap[K, V]) bury(n *Node[K, V], e *Entry[K, V]) bool {
+	if !atomic.CompareAndSwapInt32(&e.flag, 0, 1) {
+		return false
+	}
+	atomic.AddInt64(&n.size, -1)
+	atomic.AddInt64(&m.size, -1)
+	atomic.AddInt64(&m.tombstones, 1)
+	var zero V
+	m.notify(OpLogicDel, e, e.Value(), zero, nil)
+	if m.purge {
+		m.startJanitor()
+	}
+	return true
+}
+
+// sweepChunk is the number of nodes sweep processes per read lock, so that
+// a sweep of a large table does not hold off a resize for long.
+const sweepChunk = 256
+
+// sweep removes every entry for which fn returns true and returns how many
+// it removed. It gives up if a resize starts while it runs, reporting
+// complete as false; entries it has not reached are left for the next sweep.
+func (m *HashMap[K, V]) sweep(fn func(e *Entry[K, V]) bool) (removed int64, complete bool) {
+	m.RLock()
+	epoch, tables := atomic.LoadUint64(&m.epoch), []*Table[K, V]{m.old.Load(), m.table.Load()}
+	m.RUnlock()
+	for _, t := range tables {
+		if t == nil {
+			continue
+		}
+		for i := 0; i < t.len(); i += sweepChunk {
+			n, ok := m.sweepNodes(t, i, epoch, fn)
+			if removed += n; !ok {
+				return removed, false
+			}
+		}
+	}
+	return removed, true
+}
+
+// sweepNodes sweeps the sweepChunk nodes of t from i under the read lock,
+// reporting ok as false if a resize started since epoch. The lock is
+// released if the log of a removal panics.
+func (m *HashMap[K, V]) sweepNodes(t *Table[K, V], i int, epoch uint64, fn func(e *Entry[K, V]) bool) (removed int64, ok bool) {
+	m.RLock()
+	defer m.RUnlock()
+	if atomic.LoadUint64(&m.epoch) != epoch {
+		return 0, false
+	}
+	for j := i; j < i+sweepChunk && j < t.len(); j++ {
+		removed += m.sweepNode(t, &t.nodes[j], fn)
+	}
+	return removed, true
+}
+
+func (m *HashMap[K, V]) sweepNode(t *Table[K, V], n *Node[K, V], fn func(e *Entry[K, V]) bool) (removed int64) {
+	n.Lock()
+	defer n.Unlock()
+	if atomic.LoadInt32(&n.moved) != 0 {
+		return
+	}
+	for e := n.head.Load(); e != nil; {
+		next := e.next[t.ab].Load()
+		if fn(e) {
+			m.preserve(t, n)
+			m.remove(t, n, e)
+			removed++
+		}
+		e = next
+	}
+	return
+}
+
+func (e *Entry[K, V]) Value() V {
+	return *e.p.Load()
 }
 
-func (e *Entry) Key() interface{} {
+func (e *Entry[K, V]) Key() K {
 	return e.k
 }
 
-func (e *Entry) Flag() int32 {
-	return e.flag
+func (e *Entry[K, V]) Flag() int32 {
+	return atomic.LoadInt32(&e.flag)
 }
 
-func (m *HashMap) Foreach(fn func(e *Entry)) {
-	t := m.table
-	for _, node := range t.nodes {
-		next := node.head
-		for next != nil {
-			fn(next)
-			next = next.next[t.ab]
+// Foreach calls fn for every entry linked into the map, including logically
+// deleted and expired ones, without locking. Entries migrated by a resize
+// during the call may be visited twice or not at all; use Range for a
+// consistent iteration.
+func (m *HashMap[K, V]) Foreach(fn func(e *Entry[K, V])) {
+	t, old := m.table.Load(), m.old.Load()
+	if old != nil {
+		for i := range old.nodes {
+			if node := &old.nodes[i]; atomic.LoadInt32(&node.moved) == 0 {
+				old.walk(node, fn)
+			}
 		}
 	}
+	for i := range t.nodes {
+		t.walk(&t.nodes[i], fn)
+	}
 }
 
-func (m *HashMap) UnmarshalJSON(b []byte) error {
-	data := map[string]interface{}{}
-	err := json.Unmarshal(b, &data)
-	if err != nil {
-		return err
+func (t *Table[K, V]) walk(n *Node[K, V], fn func(e *Entry[K, V])) {
+	next := n.head.Load()
+	for next != nil {
+		fn(next)
+		next = next.next[t.ab].Load()
 	}
-	for k, v := range data {
-		m.Set(k, v)
+}
+
+// Range calls fn for each key and value present in the map, stopping if fn
+// returns false. Logically deleted and expired entries are skipped.
+//
+// Every key present for the whole call is visited exactly once, even if the
+// table is resized meanwhile; keys set or deleted during the call may or
+// may not be visited. fn is called without any lock held, so it may modify
+// the map.
+func (m *HashMap[K, V]) Range(fn func(k K, v V) bool) {
+	var buf []*Entry[K, V]
+	for pos, more := uint64(0), true; more; {
+		var end uint64
+		buf, end, more = m.rangeStep(pos, buf[:0])
+		for _, e := range buf {
+			if !fn(e.k, e.Value()) {
+				return
+			}
+		}
+		pos = end
 	}
-	return nil
 }
 
-func (m *HashMap) MarshalJSON() ([]byte, error) {
-	t := m.table
-	data := map[string]interface{}{}
-	for _, node := range t.nodes {
-		next := node.head
-		for next != nil {
-			data[fmt.Sprintf("%v", next.k)] = next.Value()
-			next = next.next[t.ab]
+// rangeStep appends to buf the live entries of the bucket holding the hash
+// whose bit reversal is pos, and returns the bit reversal of the first hash
+// of the next bucket, more being false past the last one.
+//
+// Ordering hashes by their bit reversal maps each bucket, which holds the
+// hashes sharing their low bits, to an interval of uint64 whatever the
+// capacity. Visiting those intervals in order, and skipping the part of an
+// interval before pos after the table shrank, visits every hash once.
+func (m *HashMap[K, V]) rangeStep(pos uint64, buf []*Entry[K, V]) (_ []*Entry[K, V], end uint64, more bool) {
+	m.RLock()
+	defer m.RUnlock()
+	capacity := m.table.Load().len()
+	if old := m.old.Load(); old != nil && old.len() > capacity {
+		capacity = old.len()
+	}
+	// buckets of the larger table are whole in either table, a single
+	// bucket spans all of uint64 and has a width of 0
+	width := uint64(1) << (64 - bits.TrailingZeros(uint(capacity)))
+	end = pos&^(width-1) + width
+	t, n := m.lockNode(bits.Reverse64(pos))
+	defer n.Unlock()
+	for e := n.head.Load(); e != nil; e = e.next[t.ab].Load() {
+		if r := bits.Reverse64(e.hash); r >= pos && (r < end || end == 0) &&
+			atomic.LoadInt32(&e.flag) == 0 && !e.expired() {
+			buf = append(buf, e)
 		}
 	}
-	return json.Marshal(data)
+	return buf, end, end != 0
 }
+
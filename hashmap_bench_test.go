@@ -1,16 +1,20 @@
 package hashmap
 
 import (
+    "io"
+    "math/rand"
+    "sort"
     "strconv"
     "sync"
     "sync/atomic"
     "testing"
+    "time"
 )
 
 const benchmarkItemCount = 1 << 10 // 1024
 
-func setupHashMap(b *testing.B) *HashMap {
-    m := New()
+func setupHashMap(b *testing.B) *AnyMap {
+    m := NewAny()
     for i := uintptr(0); i < benchmarkItemCount; i++ {
         m.Set(i, i)
     }
@@ -45,7 +49,7 @@ func BenchmarkStrconv(b *testing.B) {
 }
 
 func BenchmarkSingleInsertAbsent(b *testing.B) {
-    m := New()
+    m := NewAny()
     b.ResetTimer()
     for i := 0; i < b.N; i++ {
         m.Set(strconv.Itoa(i), "value")
@@ -61,7 +65,7 @@ func BenchmarkSingleInsertAbsentSyncMap(b *testing.B) {
 }
 
 func BenchmarkSingleInsertPresent(b *testing.B) {
-    m := New()
+    m := NewAny()
     m.Set("key", "value")
     b.ResetTimer()
     for i := 0; i < b.N; i++ {
@@ -79,7 +83,7 @@ func BenchmarkSingleInsertPresentSyncMap(b *testing.B) {
 }
 
 func BenchmarkMultiInsertDifferent(b *testing.B) {
-    m := New()
+    m := NewAny()
     finished := make(chan struct{}, b.N)
     _, set := GetSet(m, finished)
     b.ResetTimer()
@@ -106,7 +110,7 @@ func BenchmarkMultiInsertDifferentSyncMap(b *testing.B) {
 }
 
 func BenchmarkMultiInsertSame(b *testing.B) {
-    m := New()
+    m := NewAny()
     finished := make(chan struct{}, b.N)
     _, set := GetSet(m, finished)
     m.Set("key", "value")
@@ -134,7 +138,7 @@ func BenchmarkMultiInsertSameSyncMap(b *testing.B) {
 }
 
 func BenchmarkMultiGetSame(b *testing.B) {
-    m := New()
+    m := NewAny()
     finished := make(chan struct{}, b.N)
     get, _ := GetSet(m, finished)
     m.Set("key", "value")
@@ -162,7 +166,7 @@ func BenchmarkMultiGetSameSyncMap(b *testing.B) {
 }
 
 func BenchmarkMultiGetSetDifferent(b *testing.B) {
-    m := New()
+    m := NewAny()
     finished := make(chan struct{}, 2*b.N)
     get, set := GetSet(m, finished)
     m.Set("-1", "value")
@@ -192,7 +196,7 @@ func BenchmarkMultiGetSetDifferentSyncMap(b *testing.B) {
 }
 
 func BenchmarkMultiGetSetBlock(b *testing.B) {
-    m := New()
+    m := NewAny()
     finished := make(chan struct{}, 2*b.N)
     get, set := GetSet(m, finished)
     for i := 0; i < b.N; i++ {
@@ -225,7 +229,7 @@ func BenchmarkMultiGetSetBlockSyncMap(b *testing.B) {
     }
 }
 
-func GetSet(m *HashMap, finished chan struct{}) (set func(key, value string), get func(key, value string)) {
+func GetSet(m *AnyMap, finished chan struct{}) (set func(key, value string), get func(key, value string)) {
     return func(key, value string) {
             for i := 0; i < 10; i++ {
                 m.Get(key)
@@ -396,7 +400,7 @@ func BenchmarkReadGoSyncMapWithWritesUint(b *testing.B) {
 }
 
 func BenchmarkWriteHashMapUint(b *testing.B) {
-    m := New()
+    m := NewAny()
 
     for n := 0; n < b.N; n++ {
         for i := uintptr(0); i < benchmarkItemCount; i++ {
@@ -437,3 +441,160 @@ func BenchmarkWriteGoSyncMapUint(b *testing.B) {
         }
     }
 }
+
+// BenchmarkSetLatencyAcrossResize inserts b.N new keys, crossing every
+// resize up to that size, and reports the tail latency of a single Set.
+func BenchmarkSetLatencyAcrossResize(b *testing.B) {
+    m := New[int, int]()
+    latencies := make([]time.Duration, b.N)
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        start := time.Now()
+        m.Set(i, i)
+        latencies[i] = time.Since(start)
+    }
+    b.StopTimer()
+    sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+    b.ReportMetric(float64(latencies[len(latencies)*99/100]), "p99-ns")
+    b.ReportMetric(float64(latencies[len(latencies)*999/1000]), "p999-ns")
+    b.ReportMetric(float64(latencies[len(latencies)-1]), "max-ns")
+}
+
+const (
+    hitRatioEntries = 1000
+    hitRatioKeys    = 100000
+    hitRatioOps     = 500000
+)
+
+// zipfTrace returns keys drawn from a zipf distribution over hitRatioKeys.
+func zipfTrace(n int) []uint64 {
+    z := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, hitRatioKeys-1)
+    trace := make([]uint64, n)
+    for i := range trace {
+        trace[i] = z.Uint64()
+    }
+    return trace
+}
+
+// scanTrace interleaves a zipf trace with scans over keys never used again.
+func scanTrace(n int) []uint64 {
+    trace := zipfTrace(n)
+    scanned := uint64(hitRatioKeys)
+    for i := 0; i+3*hitRatioEntries <= n; i += 10 * hitRatioEntries {
+        for j := i; j < i+3*hitRatioEntries; j++ {
+            trace[j] = scanned
+            scanned++
+        }
+    }
+    return trace
+}
+
+func benchmarkHitRatio(b *testing.B, policy EvictionPolicy, trace []uint64) {
+    for i := 0; i < b.N; i++ {
+        m, err := NewWithOptions[uint64, uint64](WithMaxEntries(hitRatioEntries), WithEvictionPolicy(policy))
+        if err != nil {
+            b.Fatal(err)
+        }
+        hits := 0
+        for _, k := range trace {
+            if _, ok := m.Get(k); ok {
+                hits++
+            } else {
+                m.Set(k, k)
+            }
+        }
+        b.ReportMetric(100*float64(hits)/float64(len(trace)), "hit%")
+    }
+}
+
+func BenchmarkHitRatioZipfLRU(b *testing.B) {
+    benchmarkHitRatio(b, EvictLRU, zipfTrace(hitRatioOps))
+}
+
+func BenchmarkHitRatioZipfTinyLFU(b *testing.B) {
+    benchmarkHitRatio(b, EvictTinyLFU, zipfTrace(hitRatioOps))
+}
+
+func BenchmarkHitRatioScanLRU(b *testing.B) {
+    benchmarkHitRatio(b, EvictLRU, scanTrace(hitRatioOps))
+}
+
+func BenchmarkHitRatioScanTinyLFU(b *testing.B) {
+    benchmarkHitRatio(b, EvictTinyLFU, scanTrace(hitRatioOps))
+}
+
+const batchBenchmarkSize = 1000
+
+func batchKeys() []int {
+    ks := make([]int, batchBenchmarkSize)
+    for i := range ks {
+        ks[i] = i
+    }
+    return ks
+}
+
+func BenchmarkMSet(b *testing.B) {
+    m, ks := New[int, int](), batchKeys()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        m.MSet(ks, ks)
+    }
+}
+
+func BenchmarkMSetLoop(b *testing.B) {
+    m, ks := New[int, int](), batchKeys()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        for _, k := range ks {
+            m.Set(k, k)
+        }
+    }
+}
+
+func BenchmarkMDel(b *testing.B) {
+    m, ks := New[int, int](), batchKeys()
+    for i := 0; i < b.N; i++ {
+        b.StopTimer()
+        m.MSet(ks, ks)
+        b.StartTimer()
+        m.MDel(ks)
+    }
+}
+
+func BenchmarkMDelLoop(b *testing.B) {
+    m, ks := New[int, int](), batchKeys()
+    for i := 0; i < b.N; i++ {
+        b.StopTimer()
+        m.MSet(ks, ks)
+        b.StartTimer()
+        for _, k := range ks {
+            m.Del(k)
+        }
+    }
+}
+
+func jsonBenchMap() *HashMap[int, string] {
+    m := New[int, string]()
+    for i := 0; i < 100000; i++ {
+        m.Set(i, strconv.Itoa(i))
+    }
+    return m
+}
+
+func BenchmarkMarshalJSON(b *testing.B) {
+    m := jsonBenchMap()
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        m.MarshalJSON()
+    }
+}
+
+func BenchmarkEncodeJSON(b *testing.B) {
+    m := jsonBenchMap()
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        m.EncodeJSON(io.Discard)
+    }
+}
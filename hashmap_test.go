@@ -5,12 +5,13 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestNewHashMap(t *testing.T) {
-	hm := New()
+	hm := NewAny()
 	batch := 1000000
 	start := time.Now().UnixNano()
 	for i := 0; i < batch; i++ {
@@ -31,7 +32,7 @@ func TestNewHashMap(t *testing.T) {
 }
 
 func TestHashMap_MSet(t *testing.T) {
-	hm := New()
+	hm := NewAny()
 	batch := 1000000
 	ks := make([]interface{}, batch)
 	vs := make([]interface{}, batch)
@@ -49,7 +50,7 @@ func TestHashMap_MSet(t *testing.T) {
 }
 
 func TestHashMap_SetNil(t *testing.T) {
-	m := New()
+	m := NewAny()
 	m.Set("a", nil)
 	_, ok := m.Get("a")
 	if !ok {
@@ -58,7 +59,7 @@ func TestHashMap_SetNil(t *testing.T) {
 }
 
 func TestHashMapCorrectness(t *testing.T) {
-	hm := New()
+	hm := NewAny()
 	batch := 1000000
 	start := time.Now().UnixNano()
 	for i := 0; i < batch; i++ {
@@ -111,7 +112,7 @@ func TestHashMapCorrectness(t *testing.T) {
 }
 
 func TestNewHashMap_Sync(t *testing.T) {
-	hm := New()
+	hm := NewAny()
 	batch := 100000
 	wg := sync.WaitGroup{}
 	wg.Add(batch)
@@ -143,7 +144,7 @@ func TestNewHashMap_Sync(t *testing.T) {
 }
 
 func TestHashMap_MarshalJSON(t *testing.T) {
-	m := New()
+	m := NewAny()
 	m.Set("abc", "haha")
 	m.Set(1, 2)
 	m.Set("m", map[string]string{
@@ -158,7 +159,7 @@ func TestHashMap_MarshalJSON(t *testing.T) {
 
 func TestHashMap_UnmarshalJSON(t *testing.T) {
 	jsonStr := "{\"1\":2,\"abc\":\"haha\",\"m\":{\"hello\":\"world\"}}"
-	m := New()
+	m := NewAny()
 	err := json.Unmarshal([]byte(jsonStr), m)
 	if err != nil {
 		t.Fatal(err)
@@ -172,7 +173,7 @@ func TestHashMap_UnmarshalJSON(t *testing.T) {
 }
 
 func TestHashMap_SetNX(t *testing.T) {
-	m := New()
+	m := NewAny()
 	b := m.SetNX("a", "b")
 	assertEqual(t, b, true)
 	b = m.SetNX("a", "b")
@@ -184,3 +185,281 @@ func assertEqual(t *testing.T, a interface{}, b interface{}) {
 		t.Fatal(fmt.Sprintf("%v not equal %v", a, b))
 	}
 }
+
+func TestHashMap_Typed(t *testing.T) {
+	m := New[string, int]()
+	assertEqual(t, m.Set("a", 1), 0)
+	assertEqual(t, m.Set("a", 2), 1)
+	assertEqual(t, m.SetNX("b", 3), true)
+	v, ok := m.Get("a")
+	assertEqual(t, ok, true)
+	assertEqual(t, v, 2)
+	assertEqual(t, m.Del("a"), true)
+	_, ok = m.Get("a")
+	assertEqual(t, ok, false)
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2 := New[string, int]()
+	if err = json.Unmarshal(b, m2); err != nil {
+		t.Fatal(err)
+	}
+	v, ok = m2.Get("b")
+	assertEqual(t, ok, true)
+	assertEqual(t, v, 3)
+
+	if err = json.Unmarshal(b, New[int, int]()); err == nil {
+		t.Fatal("expected key type error")
+	}
+}
+
+func TestHashMap_BucketDistribution(t *testing.T) {
+	batch := 100000
+	for _, stride := range []int{1, 1 << 10, 1 << 16, 1 << 32} {
+		m := New[int, int]()
+		for i := 0; i < batch; i++ {
+			m.Set(i*stride, i)
+		}
+		max := int64(0)
+		for i := range m.table.Load().nodes {
+			if n := m.table.Load().nodes[i].size; n > max {
+				max = n
+			}
+		}
+		if max > 16 {
+			t.Fatalf("stride %d: %d buckets, largest holds %d entries", stride, m.table.Load().len(), max)
+		}
+	}
+}
+
+func TestHashMap_Shrink(t *testing.T) {
+	hm := New[int, int]()
+	batch := 100000
+	for i := 0; i < batch; i++ {
+		hm.Set(i, i)
+	}
+	grown := hm.table.Load().len()
+	for i := 0; i < batch; i++ {
+		if i%10 != 0 {
+			hm.Del(i)
+		}
+	}
+	if hm.table.Load().len() > grown/4 {
+		t.Fatalf("table not shrunk: %d nodes, was %d", hm.table.Load().len(), grown)
+	}
+	for i := 0; i < batch; i++ {
+		v, ok := hm.Get(i)
+		if ok != (i%10 == 0) || ok && v != i {
+			t.Fatal("data err ", i)
+		}
+	}
+	for i := 0; i < batch; i += 10 {
+		hm.Del(i)
+	}
+	assertEqual(t, hm.Size(), int64(0))
+	assertEqual(t, hm.table.Load().len(), defaultCapacity)
+}
+
+func TestHashMap_ShrinkInStep(t *testing.T) {
+	for _, lw := range []float64{0.05, 0.5, 1} {
+		hm, err := NewWithOptions[int, int](WithLowWaterMark(lw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch := 100000
+		for i := 0; i < batch; i++ {
+			hm.Set(i, i)
+		}
+		// a shrink starting while a migration is unfinished would migrate
+		// the rest under the write lock
+		shrinks := 0
+		for i := 0; i < batch; i++ {
+			var left int64
+			if old := hm.old.Load(); old != nil {
+				left = int64(old.len()) - old.moved
+			}
+			epoch := hm.epoch
+			hm.Del(i)
+			if hm.epoch == epoch {
+				continue
+			}
+			shrinks++
+			if left > 0 {
+				t.Fatalf("low-water mark %v: shrink forced a migration of %d nodes", lw, left)
+			}
+		}
+		if hm.table.Load().len() > 256 {
+			t.Fatalf("low-water mark %v: %d shrinks, %d nodes left", lw, shrinks, hm.table.Load().len())
+		}
+	}
+}
+
+func TestHashMap_Compact(t *testing.T) {
+	hm, err := NewWithOptions[int, int](WithLowWaterMark(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := 100000
+	for i := 0; i < batch; i++ {
+		hm.Set(i, i)
+	}
+	grown := hm.table.Load().len()
+	for i := 100; i < batch; i++ {
+		hm.Del(i)
+	}
+	assertEqual(t, hm.table.Load().len(), grown)
+	hm.Compact()
+	assertEqual(t, hm.table.Load().len(), 128)
+	if hm.old.Load() != nil {
+		t.Fatal("compact left a migration running")
+	}
+	for i := 0; i < 100; i++ {
+		v, ok := hm.Get(i)
+		if !ok || v != i {
+			t.Fatal("data err ", i)
+		}
+	}
+}
+
+func TestHashMap_ConcurrentResize(t *testing.T) {
+	hm := New[int, int]()
+	stable := 1000
+	for i := 0; i < stable; i++ {
+		hm.Set(i, i)
+	}
+	done := make(chan struct{})
+	writers := sync.WaitGroup{}
+	for w := 1; w <= 4; w++ {
+		writers.Add(1)
+		go func(base int) {
+			defer writers.Done()
+			for round := 0; round < 3; round++ {
+				for i := 0; i < 10000; i++ {
+					hm.Set(base+i, i)
+				}
+				for i := 0; i < 10000; i++ {
+					hm.Del(base + i)
+				}
+			}
+		}(w * 1000000)
+	}
+	readers := sync.WaitGroup{}
+	misses := int64(0)
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for i := 0; i < stable; i++ {
+					if v, ok := hm.Get(i); !ok || v != i {
+						atomic.AddInt64(&misses, 1)
+					}
+				}
+				hm.Foreach(func(e *Entry[int, int]) {
+					_ = e.Value()
+				})
+			}
+		}()
+	}
+	writers.Wait()
+	close(done)
+	readers.Wait()
+	assertEqual(t, misses, int64(0))
+	assertEqual(t, hm.Size(), int64(stable))
+	if hm.epoch < 10 {
+		t.Fatal("map did not resize, epoch", hm.epoch)
+	}
+}
+
+func TestHashMap_Range(t *testing.T) {
+	hm := New[int, int]()
+	for i := 0; i < 100; i++ {
+		hm.Set(i, i)
+	}
+	hm.LogicDel(10)
+	hm.Del(20)
+	seen := map[int]int{}
+	hm.Range(func(k, v int) bool {
+		assertEqual(t, k, v)
+		seen[k]++
+		return true
+	})
+	assertEqual(t, len(seen), 98)
+	if seen[10] != 0 || seen[20] != 0 {
+		t.Fatal("visited deleted keys")
+	}
+	n := 0
+	hm.Range(func(k, v int) bool {
+		n++
+		return n < 5
+	})
+	assertEqual(t, n, 5)
+
+	// deleting from fn is allowed and does not affect the remaining keys
+	n = 0
+	hm.Range(func(k, v int) bool {
+		hm.Del(k)
+		n++
+		return true
+	})
+	assertEqual(t, n, 98)
+	assertEqual(t, hm.Size(), int64(0))
+}
+
+func TestHashMap_RangeConcurrentResize(t *testing.T) {
+	hm, err := NewWithOptions[int, int](WithCapacity(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stable := 1000
+	for i := 0; i < stable; i++ {
+		hm.Set(i, i)
+	}
+	done := make(chan struct{})
+	writers := sync.WaitGroup{}
+	for w := 1; w <= 4; w++ {
+		writers.Add(1)
+		go func(base int) {
+			defer writers.Done()
+			for {
+				for i := 0; i < 3000; i++ {
+					hm.Set(base+i, i)
+				}
+				for i := 0; i < 3000; i++ {
+					hm.Del(base + i)
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}(w * 1000000)
+	}
+	for round := 0; round < 500; round++ {
+		seen := make([]int, stable)
+		hm.Range(func(k, v int) bool {
+			if k < stable {
+				seen[k]++
+			}
+			return true
+		})
+		for k, n := range seen {
+			if n != 1 {
+				t.Fatalf("round %d: key %d visited %d times", round, k, n)
+			}
+		}
+	}
+	close(done)
+	writers.Wait()
+	if hm.epoch < 10 {
+		t.Fatal("map did not resize, epoch", hm.epoch)
+	}
+}
@@ -0,0 +1,353 @@
+package hashmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrKeyCollision is returned by MarshalJSON when distinct keys, such as 1
+// and "1", format to the same JSON object key.
+var ErrKeyCollision = errors.New("hashmap: JSON key collision")
+
+// WithTypedJSON makes MarshalJSON encode the map as an array of entries
+// recording the type of each key, {"k": key, "t": type, "v": value}, so that
+// keys keep their types through JSON. The types are named after the Go
+// types, "nil" for a nil key and "time" for time.Time. UnmarshalJSON reads
+// both formats whatever the option.
+func WithTypedJSON() Option {
+	return func(c *config) {
+		c.typedJSON = true
+	}
+}
+
+// WithJSONKeyType declares the type UnmarshalJSON parses the object keys of
+// the plain JSON format into. It must be one of the types SaveTo supports
+// and be assignable to the key type of the map. It defaults to the key type
+// of the map, or string if that is an interface type.
+func WithJSONKeyType[T comparable]() Option {
+	return func(c *config) {
+		c.jsonKey = reflect.TypeFor[T]()
+	}
+}
+
+var tagNames = [...]string{
+	tagNil:     "nil",
+	tagString:  "string",
+	tagBool:    "bool",
+	tagTime:    "time",
+	tagInt:     "int",
+	tagInt8:    "int8",
+	tagInt16:   "int16",
+	tagInt32:   "int32",
+	tagInt64:   "int64",
+	tagUint:    "uint",
+	tagUint8:   "uint8",
+	tagUint16:  "uint16",
+	tagUint32:  "uint32",
+	tagUint64:  "uint64",
+	tagFloat32: "float32",
+	tagFloat64: "float64",
+	tagUintptr: "uintptr",
+}
+
+var tagBits = [...]int{
+	tagInt:     strconv.IntSize,
+	tagInt8:    8,
+	tagInt16:   16,
+	tagInt32:   32,
+	tagInt64:   64,
+	tagUint:    strconv.IntSize,
+	tagUint8:   8,
+	tagUint16:  16,
+	tagUint32:  32,
+	tagUint64:  64,
+	tagFloat32: 32,
+	tagFloat64: 64,
+	tagUintptr: 64,
+}
+
+// typedEntry is an entry of the typed JSON format.
+type typedEntry[K, V any] struct {
+	K K      `json:"k"`
+	T string `json:"t"`
+	V V      `json:"v"`
+}
+
+// jsonKeyType returns the type of the keys of the plain JSON format of a map
+// with keys of type K: K if SaveTo supports it, string otherwise.
+func jsonKeyType[K comparable]() reflect.Type {
+	if t := reflect.TypeFor[K](); t.Kind() != reflect.Interface {
+		if _, ok := keyTag(t); ok {
+			return t
+		}
+	}
+	return tagTypes[tagString]
+}
+
+// parseKey parses s, formatted by %v or as JSON, into the key type of tag.
+func parseKey(tag byte, s string) (any, error) {
+	switch tag {
+	case tagString:
+		return s, nil
+	case tagBool:
+		return strconv.ParseBool(s)
+	case tagTime:
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, nil
+		}
+		// as formatted by %v, without the monotonic clock reading
+		s, _, _ = strings.Cut(s, " m=")
+		return time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", s)
+	case tagInt, tagInt8, tagInt16, tagInt32, tagInt64:
+		i, err := strconv.ParseInt(s, 10, tagBits[tag])
+		return intKey(tag, i), err
+	case tagUint, tagUint8, tagUint16, tagUint32, tagUint64, tagUintptr:
+		u, err := strconv.ParseUint(s, 10, tagBits[tag])
+		return uintKey(tag, u), err
+	case tagFloat32:
+		f, err := strconv.ParseFloat(s, 32)
+		return float32(f), err
+	case tagFloat64:
+		return strconv.ParseFloat(s, 64)
+	}
+	return nil, fmt.Errorf("hashmap: cannot parse key %q", s)
+}
+
+// parseTypedKey parses the key of an entry of the typed JSON format.
+func parseTypedKey(t string, raw json.RawMessage) (any, error) {
+	for tag, name := range tagNames {
+		if name != t {
+			continue
+		}
+		switch byte(tag) {
+		case tagNil:
+			if string(raw) != "null" {
+				return nil, fmt.Errorf("hashmap: nil JSON key %s", raw)
+			}
+			return nil, nil
+		case tagString, tagTime:
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return nil, err
+			}
+			return parseKey(byte(tag), s)
+		}
+		return parseKey(byte(tag), string(raw))
+	}
+	return nil, fmt.Errorf("hashmap: unknown JSON key type %q", t)
+}
+
+// UnmarshalJSON sets the entries of b, in the plain or the typed format of
+// MarshalJSON, in the map. The object keys of the plain format are parsed
+// into the type declared by WithJSONKeyType. Nothing is set if b cannot be
+// decoded entirely.
+func (m *HashMap[K, V]) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimLeft(b, " \t\r\n"); len(b) > 0 && b[0] == '[' {
+		return m.unmarshalTypedJSON(b)
+	}
+	data := map[string]V{}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	ks, vs := make([]K, 0, len(data)), make([]V, 0, len(data))
+	for s, v := range data {
+		k, err := m.parseJSONKey(s)
+		if err != nil {
+			return err
+		}
+		ks, vs = append(ks, k), append(vs, v)
+	}
+	return m.MSet(ks, vs)
+}
+
+func (m *HashMap[K, V]) parseJSONKey(s string) (k K, err error) {
+	// a type defined over the kind of a tag, such as type ID string, is
+	// parsed as the type of the tag and converted
+	tag, _ := keyTag(m.jsonKey)
+	key, err := parseKey(tag, s)
+	if err != nil {
+		return k, fmt.Errorf("hashmap: cannot unmarshal JSON object key %q into %v: %w", s, m.jsonKey, err)
+	}
+	if m.jsonKey != tagTypes[tag] {
+		key = reflect.ValueOf(key).Convert(m.jsonKey).Interface()
+	}
+	return keyOf[K](key)
+}
+
+func (m *HashMap[K, V]) unmarshalTypedJSON(b []byte) error {
+	var entries []typedEntry[json.RawMessage, V]
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+	ks, vs := make([]K, len(entries)), make([]V, len(entries))
+	for i, e := range entries {
+		key, err := parseTypedKey(e.T, e.K)
+		if err != nil {
+			return err
+		}
+		if ks[i], err = keyOf[K](key); err != nil {
+			return err
+		}
+		vs[i] = e.V
+	}
+	return m.MSet(ks, vs)
+}
+
+// MarshalJSON encodes the map as a JSON object, formatting keys with %v, or
+// in the typed format under WithTypedJSON. It returns an error wrapping
+// ErrKeyCollision if keys of different types format the same.
+func (m *HashMap[K, V]) MarshalJSON() ([]byte, error) {
+	if m.typedJSON {
+		return m.marshalTypedJSON()
+	}
+	data := map[string]V{}
+	var err error
+	m.Range(func(k K, v V) bool {
+		s := fmt.Sprintf("%v", k)
+		if _, ok := data[s]; ok {
+			err = fmt.Errorf("%w: %q, set WithTypedJSON to tell the keys apart", ErrKeyCollision, s)
+			return false
+		}
+		data[s] = v
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+func (m *HashMap[K, V]) marshalTypedJSON() ([]byte, error) {
+	entries := []typedEntry[any, V]{}
+	var b []byte
+	var err error
+	m.Range(func(k K, v V) bool {
+		if b, err = appendKey(b[:0], k); err != nil {
+			return false
+		}
+		entries = append(entries, typedEntry[any, V]{K: k, T: tagNames[b[0]], V: v})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(entries)
+}
+
+// EncodeJSON writes the map to w in the format of MarshalJSON, streaming the
+// entries bucket by bucket as Range visits them, so that the memory used
+// does not grow with the map. Entries are written in no particular order.
+// Unlike MarshalJSON, it does not detect key collisions, which would take
+// memory proportional to the map; use WithTypedJSON if keys of different
+// types may format the same.
+func (m *HashMap[K, V]) EncodeJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// encode writes x as compact JSON, without the newline of Encode
+	encode := func(x any) error {
+		buf.Reset()
+		if err := enc.Encode(x); err != nil {
+			return err
+		}
+		_, err := bw.Write(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}))
+		return err
+	}
+
+	opening, closing := byte('{'), byte('}')
+	if m.typedJSON {
+		opening, closing = '[', ']'
+	}
+	bw.WriteByte(opening)
+	var b []byte
+	var err error
+	first := true
+	m.Range(func(k K, v V) bool {
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		if m.typedJSON {
+			if b, err = appendKey(b[:0], k); err == nil {
+				err = encode(typedEntry[any, V]{K: k, T: tagNames[b[0]], V: v})
+			}
+			return err == nil
+		}
+		if err = encode(fmt.Sprintf("%v", k)); err == nil {
+			bw.WriteByte(':')
+			err = encode(v)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	bw.WriteByte(closing)
+	return bw.Flush()
+}
+
+// DecodeJSON reads a JSON value in either format of MarshalJSON from r and
+// sets its entries in the map as UnmarshalJSON does, streaming them through
+// the tokens of a json.Decoder so that the memory used does not grow with
+// the input. Unlike UnmarshalJSON, entries decoded before an error may have
+// been set.
+func (m *HashMap[K, V]) DecodeJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	typed := tok == json.Delim('[')
+	if !typed && tok != json.Delim('{') {
+		return fmt.Errorf("hashmap: cannot decode JSON %v into a map", tok)
+	}
+	ks, vs := make([]K, 0, batchChunk), make([]V, 0, batchChunk)
+	for dec.More() {
+		var k K
+		var v V
+		if typed {
+			var e typedEntry[json.RawMessage, V]
+			if err = dec.Decode(&e); err != nil {
+				return err
+			}
+			key, err := parseTypedKey(e.T, e.K)
+			if err != nil {
+				return err
+			}
+			if k, err = keyOf[K](key); err != nil {
+				return err
+			}
+			v = e.V
+		} else {
+			if tok, err = dec.Token(); err != nil {
+				return err
+			}
+			if k, err = m.parseJSONKey(tok.(string)); err != nil {
+				return err
+			}
+			if err = dec.Decode(&v); err != nil {
+				return err
+			}
+		}
+		if ks, vs = append(ks, k), append(vs, v); len(ks) == batchChunk {
+			if err = m.MSet(ks, vs); err != nil {
+				return err
+			}
+			ks, vs = ks[:0], vs[:0]
+		}
+	}
+	// the closing delimiter
+	if _, err = dec.Token(); err != nil {
+		return err
+	}
+	return m.MSet(ks, vs)
+}
@@ -0,0 +1,213 @@
+package hashmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashMap_TypedJSON(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	keys := []any{nil, "1", true, at, int(1), int8(-2), int16(-3), int32(-4), int64(-1 << 62),
+		uint(1), uint8(2), uint16(3), uint32(4), uint64(1<<64 - 1), uintptr(6), float32(1.5), float64(2.5)}
+	m, err := NewWithOptions[any, any](WithTypedJSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, k := range keys {
+		m.Set(k, i)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `{"k":"1","t":"string","v":1}`) {
+		t.Fatal("unexpected typed JSON", string(b))
+	}
+
+	l := NewAny()
+	if err = json.Unmarshal(b, l); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, l.Size(), int64(len(keys)))
+	for i, k := range keys {
+		v, ok := l.Get(k)
+		if !ok || v != float64(i) {
+			t.Fatalf("key %T(%v): %v %v", k, k, v, ok)
+		}
+	}
+
+	for _, bad := range []string{
+		`[{"k":1,"t":"complex","v":0}]`,
+		`[{"k":300,"t":"uint8","v":0}]`,
+		`[{"k":"x","t":"int","v":0}]`,
+		`[{"k":1,"t":"nil","v":0}]`,
+	} {
+		if err = json.Unmarshal([]byte(bad), NewAny()); err == nil {
+			t.Fatal("decoded", bad)
+		}
+	}
+	typed := New[string, int]()
+	if err = json.Unmarshal([]byte(`[{"k":"a","t":"string","v":1},{"k":2,"t":"int","v":2}]`), typed); err == nil {
+		t.Fatal("decoded an int key into a string map")
+	}
+	assertEqual(t, typed.Size(), int64(0))
+}
+
+func TestHashMap_JSONKeyType(t *testing.T) {
+	m := New[int, string]()
+	m.Set(1, "a")
+	m.Set(-2, "b")
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New[int, string]()
+	if err = json.Unmarshal(b, l); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := l.Get(-2)
+	assertEqual(t, v, "b")
+	if err = json.Unmarshal([]byte(`{"x":"c"}`), l); err == nil {
+		t.Fatal("decoded a string key into an int map")
+	}
+
+	a, err := NewWithOptions[any, string](WithJSONKeyType[int64]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(b, a); err != nil {
+		t.Fatal(err)
+	}
+	v, _ = a.Get(int64(1))
+	assertEqual(t, v, "a")
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	tm := New[time.Time, int]()
+	tm.Set(at, 1)
+	tm.Set(time.Now(), 2)
+	b, _ = json.Marshal(tm)
+	tl := New[time.Time, int]()
+	if err = json.Unmarshal(b, tl); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, tl.Size(), int64(2))
+	v2, _ := tl.Get(at)
+	assertEqual(t, v2, 1)
+
+	if _, err = NewWithOptions[string, int](WithJSONKeyType[int]()); err == nil {
+		t.Fatal("accepted a JSON key type not assignable to the key type")
+	}
+	if _, err = NewWithOptions[any, int](WithJSONKeyType[struct{}]()); err == nil {
+		t.Fatal("accepted an unsupported JSON key type")
+	}
+}
+
+func TestHashMap_JSONDefinedKey(t *testing.T) {
+	type id string
+	type level int8
+	for _, typed := range []bool{false, true} {
+		var opts []Option
+		if typed {
+			opts = append(opts, WithTypedJSON())
+		}
+		m, _ := NewWithOptions[id, level](opts...)
+		m.Set("a", 1)
+		m.Set("b", -2)
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := New[id, level]()
+		if err = json.Unmarshal(b, l); err != nil {
+			t.Fatal(typed, err)
+		}
+		assertEqual(t, l.Size(), int64(2))
+		v, _ := l.Get("b")
+		assertEqual(t, v, level(-2))
+
+		n, _ := NewWithOptions[level, id](opts...)
+		n.Set(-3, "x")
+		b, _ = json.Marshal(n)
+		r := New[level, id]()
+		if err = json.Unmarshal(b, r); err != nil {
+			t.Fatal(typed, err)
+		}
+		s, _ := r.Get(-3)
+		assertEqual(t, s, id("x"))
+	}
+
+	a, err := NewWithOptions[any, int](WithJSONKeyType[id]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal([]byte(`{"a":1}`), a); err != nil {
+		t.Fatal(err)
+	}
+	v, ok := a.Get(id("a"))
+	assertEqual(t, ok && v == 1, true)
+}
+
+func TestHashMap_JSONKeyCollision(t *testing.T) {
+	m := NewAny()
+	m.Set(1, "int")
+	m.Set("1", "string")
+	if _, err := json.Marshal(m); !errors.Is(err, ErrKeyCollision) {
+		t.Fatal("collision not detected:", err)
+	}
+}
+
+func TestHashMap_EncodeDecodeJSON(t *testing.T) {
+	for _, typed := range []bool{false, true} {
+		var opts []Option
+		if typed {
+			opts = append(opts, WithTypedJSON())
+		}
+		m, _ := NewWithOptions[int, []string](opts...)
+		for i := 0; i < 10000; i++ {
+			m.Set(i, []string{strconv.Itoa(i)})
+		}
+
+		// stream from one map to the other
+		r, w := io.Pipe()
+		go func() {
+			w.CloseWithError(m.EncodeJSON(w))
+		}()
+		l := New[int, []string]()
+		if err := l.DecodeJSON(r); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, l.Size(), m.Size())
+		for i := 0; i < 10000; i++ {
+			if v, ok := l.Get(i); !ok || len(v) != 1 || v[0] != strconv.Itoa(i) {
+				t.Fatal("decode err", typed, i, v, ok)
+			}
+		}
+
+		// both formats are those of MarshalJSON
+		var b bytes.Buffer
+		assertEqual(t, m.EncodeJSON(&b), nil)
+		u := New[int, []string]()
+		assertEqual(t, json.Unmarshal(b.Bytes(), u), nil)
+		assertEqual(t, u.Size(), m.Size())
+		mb, _ := json.Marshal(m)
+		d := New[int, []string]()
+		assertEqual(t, d.DecodeJSON(bytes.NewReader(mb)), nil)
+		assertEqual(t, d.Size(), m.Size())
+	}
+
+	var b bytes.Buffer
+	assertEqual(t, New[string, int]().EncodeJSON(&b), nil)
+	assertEqual(t, b.String(), "{}")
+
+	for _, bad := range []string{`1`, `{"a":1,"b":"x"}`, `{"a":1`, `[{"k":"a","t":"int","v":1}]`} {
+		if err := New[string, int]().DecodeJSON(strings.NewReader(bad)); err == nil {
+			t.Fatal("decoded", bad)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package hashmap
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// EvictionPolicy selects how a map bounded by WithMaxEntries chooses the
+// entry to evict.
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the approximately least recently used entry.
+	EvictLRU EvictionPolicy = iota
+	// EvictTinyLFU keeps an estimate of how often every key is used,
+	// counting each read of a present key and each write once, and evicts
+	// the least frequently used entry. A new key is only admitted if it is
+	// used more often than the entry it would replace, so that a scan over
+	// many keys used once does not flush the entries used repeatedly.
+	EvictTinyLFU
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case EvictLRU:
+		return "LRU"
+	case EvictTinyLFU:
+		return "TinyLFU"
+	}
+	return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+}
+
+// WithEvictionPolicy sets the eviction policy of a map bounded by
+// WithMaxEntries. It defaults to EvictLRU.
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(c *config) {
+		c.policy = p
+	}
+}
+
+// NewTinyLFU returns a map holding at most maxEntries entries under
+// EvictTinyLFU.
+func NewTinyLFU[K comparable, V any](maxEntries int, opts ...Option) (*HashMap[K, V], error) {
+	return NewWithOptions[K, V](append(opts, WithMaxEntries(maxEntries), WithEvictionPolicy(EvictTinyLFU))...)
+}
+
+// EvictionPolicy returns the eviction policy of the map.
+func (m *HashMap[K, V]) EvictionPolicy() EvictionPolicy {
+	if m.sketch != nil {
+		return EvictTinyLFU
+	}
+	return EvictLRU
+}
+
+// record counts a use of the key hashed to h under EvictTinyLFU.
+func (m *HashMap[K, V]) record(h uint64) {
+	if m.sketch != nil {
+		m.sketch.increment(h)
+	}
+}
+
+// admit reports whether candidate should take the place of victim: if it is
+// used more often, or now and then if it is used often but not more, so that
+// a victim whose estimate is inflated by hash collisions cannot keep out
+// every candidate. It always does under EvictLRU.
+func (m *HashMap[K, V]) admit(candidate, victim *Entry[K, V]) bool {
+	if m.sketch == nil {
+		return true
+	}
+	c := m.sketch.estimate(candidate.hash)
+	if c > m.sketch.estimate(victim.hash) {
+		return true
+	}
+	return c >= admitWarmCount && rand.N(admitWarmOdds) == 0
+}
+
+const (
+	sketchDepth = 4
+	// sketchResetFactor times the number of entries is the number of
+	// increments after which all counters are halved and the doorkeeper is
+	// cleared, so that keys that stop being used age out.
+	sketchResetFactor = 32
+	sketchMaxCount    = 15
+	// doorHashes is the number of doorkeeper bits set per key.
+	doorHashes = 2
+	// A candidate whose estimate reaches admitWarmCount without beating the
+	// victim is admitted once in admitWarmOdds.
+	admitWarmCount = 6
+	admitWarmOdds  = 128
+)
+
+// sketch is a count-min sketch of 4-bit counters, 16 packed in a word,
+// approximating how often each key hash was recorded. The first use of a
+// key only sets its bits in the doorkeeper, a bloom filter, so that the keys
+// used once, usually the most numerous, do not inflate the counters.
+type sketch struct {
+	rows     [sketchDepth][]uint64
+	seeds    [sketchDepth]uint64
+	mask     uint64 // counters per row - 1
+	door     []uint64
+	doorSeed uint64
+	doorMask uint64 // doorkeeper bits - 1
+	count    int64  // increments since the last reset
+	limit    int64
+}
+
+// newSketch returns a sketch sized for a map of the given number of entries,
+// using 4 counters per entry and row and 64 doorkeeper bits per entry, or 16
+// bytes per entry in total.
+func newSketch(entries int) *sketch {
+	entries = 1 << bits.Len(uint(max(entries, 16)-1))
+	width := 4 * entries
+	s := &sketch{
+		mask:     uint64(width - 1),
+		door:     make([]uint64, entries),
+		doorSeed: rand.Uint64(),
+		doorMask: uint64(64*entries - 1),
+		limit:    int64(entries * sketchResetFactor),
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint64, width/16)
+		s.seeds[i] = rand.Uint64()
+	}
+	return s
+}
+
+// counter returns the word and bit offset of the counter for h in row i.
+func (s *sketch) counter(i int, h uint64) (*uint64, uint) {
+	c := mix(h^s.seeds[i]) & s.mask
+	return &s.rows[i][c/16], uint(c%16) * 4
+}
+
+// doorkeep sets the doorkeeper bits of h, or only checks them if check is
+// true, and reports whether they were all set.
+func (s *sketch) doorkeep(h uint64, check bool) bool {
+	seen := true
+	x := h ^ s.doorSeed
+	for range doorHashes {
+		x = mix(x)
+		bit := x & s.doorMask
+		w, b := &s.door[bit/64], uint64(1)<<(bit%64)
+		if check {
+			seen = seen && atomic.LoadUint64(w)&b != 0
+		} else {
+			seen = atomic.OrUint64(w, b)&b != 0 && seen
+		}
+	}
+	return seen
+}
+
+func (s *sketch) increment(h uint64) {
+	if s.doorkeep(h, false) {
+		for i := range s.rows {
+			w, shift := s.counter(i, h)
+			for {
+				old := atomic.LoadUint64(w)
+				if old>>shift&sketchMaxCount == sketchMaxCount || atomic.CompareAndSwapUint64(w, old, old+1<<shift) {
+					break
+				}
+			}
+		}
+	}
+	if atomic.AddInt64(&s.count, 1) == s.limit {
+		s.reset()
+	}
+}
+
+// estimate returns the number of times h was recorded, at most
+// sketchMaxCount plus one for the doorkeeper.
+func (s *sketch) estimate(h uint64) uint64 {
+	n := uint64(sketchMaxCount)
+	for i := range s.rows {
+		w, shift := s.counter(i, h)
+		n = min(n, atomic.LoadUint64(w)>>shift&sketchMaxCount)
+	}
+	if s.doorkeep(h, true) {
+		n++
+	}
+	return n
+}
+
+// reset halves every counter and clears the doorkeeper.
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			w := &s.rows[i][j]
+			for {
+				old := atomic.LoadUint64(w)
+				if atomic.CompareAndSwapUint64(w, old, old>>1&0x7777777777777777) {
+					break
+				}
+			}
+		}
+	}
+	for i := range s.door {
+		atomic.StoreUint64(&s.door[i], 0)
+	}
+	atomic.AddInt64(&s.count, -s.limit/2)
+}
@@ -0,0 +1,110 @@
+package hashmap
+
+import (
+	"testing"
+)
+
+func TestNewTinyLFU(t *testing.T) {
+	evicted := 0
+	m, err := NewTinyLFU[int, int](1000, WithEvictCallback(func(k, v int) {
+		if k != v {
+			t.Error("evicted", k, v)
+		}
+		evicted++
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, m.EvictionPolicy(), EvictTinyLFU)
+	assertEqual(t, m.MaxEntries(), 1000)
+	// keys 0-499 are used often, then a scan inserts keys used once
+	for r := 0; r < 5; r++ {
+		for i := 0; i < 500; i++ {
+			m.Set(i, i)
+		}
+	}
+	for i := 1000; i < 11000; i++ {
+		m.Set(i, i)
+	}
+	assertEqual(t, m.Size(), int64(1000))
+	assertEqual(t, evicted, 10500-1000)
+	lost := 0
+	for i := 0; i < 500; i++ {
+		if _, ok := m.Get(i); !ok {
+			lost++
+		}
+	}
+	if lost > 50 {
+		t.Fatalf("scan evicted %d of 500 frequently used keys", lost)
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := newSketch(100)
+	for i := 0; i < 10; i++ {
+		s.increment(1)
+	}
+	s.increment(2)
+	assertEqual(t, s.estimate(1) >= 10, true)
+	assertEqual(t, s.estimate(2) >= 1, true)
+	assertEqual(t, s.estimate(1) > s.estimate(2), true)
+	for i := 0; i < 100; i++ {
+		s.increment(1)
+	}
+	// the doorkeeper counts one more
+	assertEqual(t, s.estimate(1), uint64(sketchMaxCount+1))
+	s.reset()
+	assertEqual(t, s.estimate(1), uint64(sketchMaxCount/2))
+	assertEqual(t, s.estimate(2), uint64(0))
+}
+
+func TestSketch_Doorkeeper(t *testing.T) {
+	s := newSketch(100)
+	// keys used once only reach the doorkeeper
+	for h := uint64(0); h < 200; h++ {
+		s.increment(mix(h))
+	}
+	counted := 0
+	for h := uint64(0); h < 200; h++ {
+		if n := s.estimate(mix(h)); n == 0 {
+			t.Fatal("key not recorded", h)
+		} else if n > 1 {
+			counted++
+		}
+	}
+	// but for doorkeeper false positives
+	if counted > 10 {
+		t.Fatalf("%d of 200 keys used once counted more than once", counted)
+	}
+}
+
+func TestNewTinyLFU_CountOnce(t *testing.T) {
+	m, err := NewTinyLFU[int, int](100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a miss followed by an insertion is one use
+	if _, ok := m.Get(1); ok {
+		t.Fatal("unexpected hit")
+	}
+	m.Set(1, 1)
+	assertEqual(t, m.sketch.estimate(m.mustHash(1)), uint64(1))
+	m.Get(1)
+	assertEqual(t, m.sketch.estimate(m.mustHash(1)), uint64(2))
+}
+
+func TestWithEvictionPolicy_Invalid(t *testing.T) {
+	if _, err := NewWithOptions[int, int](WithEvictionPolicy(EvictTinyLFU)); err == nil {
+		t.Fatal("expected unbounded policy error")
+	}
+	if _, err := NewLRU[int, int](10, WithEvictionPolicy(EvictionPolicy(7))); err == nil {
+		t.Fatal("expected invalid policy error")
+	}
+	m, err := NewLRU[int, int](10, WithEvictionPolicy(EvictLRU))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, m.EvictionPolicy(), EvictLRU)
+	assertEqual(t, EvictTinyLFU.String(), "TinyLFU")
+	assertEqual(t, EvictionPolicy(7).String(), "EvictionPolicy(7)")
+}
@@ -0,0 +1,527 @@
+package hashmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SyncPolicy selects how often the log is synced to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncEverySecond writes and syncs the log every second, so that a
+	// crash loses at most about a second of writes.
+	SyncEverySecond SyncPolicy = iota
+	// SyncAlways syncs the log before each write returns. Writers wait for
+	// one another to be synced, and panic if the log cannot be written.
+	SyncAlways
+	// SyncNever writes the log every second and leaves syncing it to the
+	// operating system.
+	SyncNever
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncEverySecond:
+		return "EverySecond"
+	case SyncAlways:
+		return "Always"
+	case SyncNever:
+		return "Never"
+	}
+	return fmt.Sprintf("SyncPolicy(%d)", int(p))
+}
+
+const defaultLogRewriteSize = 64 << 20
+
+// The log starts with logMagic and logVersion, followed by records, each the
+// uvarint length of its body, the body and a CRC-32C of the body. A body is
+// an Op, the tagged key and, for OpSet, the expiration time in Unix
+// nanoseconds, 0 if none, and the length-prefixed encoded value.
+const (
+	logMagic   = "HLOG"
+	logVersion = 1
+)
+
+var (
+	errNoLog     = errors.New("hashmap: map has no log")
+	errLogClosed = errors.New("hashmap: log closed")
+	errLogFailed = errors.New("hashmap: cannot write log")
+)
+
+// WithLog records the writes to the map in an append-only log at path,
+// which is created if missing. NewWithOptions replays the log to rebuild the
+// map as it was when last written; a record cut short by a crash ends the
+// log. Values are encoded with the codec set by WithCodec, and keys must be
+// of a type SaveTo supports: NewWithOptions rejects other key types, and a
+// map with interface keys panics with ErrUnsupportedKey on a key it cannot
+// log, before writing it. A write whose value the codec fails to encode
+// panics as well, leaving the map unchanged.
+//
+// Every write is logged, the writes of a transaction one by one. While the
+// map has a log, Set and LogicDel lock nodes like the other writers. Call
+// Close to flush and close the log.
+//
+// An error writing or syncing the log stops it: the writes that follow
+// still change the map, but are no longer logged and are lost on restart.
+// SyncLog and Close return the error. Under SyncAlways, the write that meets
+// the error and every later one panic with it once applied to the map, the
+// janitor excepted, as an expiration missing from the log is replayed as
+// expired anyway; under the other policies, writers are not told.
+func WithLog(path string) Option {
+	return func(c *config) {
+		c.logPath = path
+	}
+}
+
+// WithLogSync sets how often the log is synced. It defaults to
+// SyncEverySecond.
+func WithLogSync(p SyncPolicy) Option {
+	return func(c *config) {
+		c.logSync = p
+	}
+}
+
+// WithLogRewriteSize sets the size in bytes from which the log is rewritten
+// by RewriteLog once it has doubled since it was last rewritten. It defaults
+// to 64 MiB; 0 disables automatic rewrites.
+func WithLogRewriteSize(n int64) Option {
+	return func(c *config) {
+		c.logRewrite = n
+	}
+}
+
+type opLog struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	w       *bufio.Writer
+	policy  SyncPolicy
+	size    int64         // bytes in the log
+	base    int64         // size of the log after the last rewrite
+	limit   int64         // size from which the log is rewritten, 0 never
+	rewrite *bytes.Buffer // records written while a rewrite runs, nil otherwise
+	auto    bool          // whether an automatic rewrite is running
+	err     error         // first error, after which nothing is written
+	closed  bool
+
+	rewriteMu sync.Mutex     // held by the rewrite running
+	rewrites  sync.WaitGroup // automatic rewrites running
+	stop      chan struct{}  // stops the flusher, nil for SyncAlways
+	done      chan struct{}
+}
+
+// openLog replays the log at path into m, then appends the writes to m to
+// it.
+func (m *HashMap[K, V]) openLog(path string, policy SyncPolicy, limit int64) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	size, err := m.replay(f)
+	if err == nil && size == 0 {
+		size, err = int64(len(logMagic)+1), f.Truncate(0)
+		if err == nil {
+			_, err = f.WriteAt(append([]byte(logMagic), logVersion), 0)
+		}
+	} else if err == nil {
+		// drop the record cut short, if any
+		err = f.Truncate(size)
+	}
+	if err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("hashmap: log %s: %w", path, err)
+	}
+	l := &opLog{path: path, f: f, w: bufio.NewWriter(f), policy: policy, size: size, base: size, limit: limit}
+	if policy != SyncAlways {
+		l.stop, l.done = make(chan struct{}), make(chan struct{})
+		go l.flusher()
+	}
+	m.oplog = l
+	return nil
+}
+
+// replay applies the records of the log in f to m and returns the size of
+// the log up to the last complete record, 0 if it has no header yet.
+func (m *HashMap[K, V]) replay(f *os.File) (size int64, err error) {
+	r := bufio.NewReader(f)
+	header := make([]byte, len(logMagic)+1)
+	if _, err = io.ReadFull(r, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if string(header[:len(logMagic)]) != logMagic {
+		return 0, fmt.Errorf("%w: bad magic %q", ErrCorrupt, header[:len(logMagic)])
+	}
+	if v := header[len(logMagic)]; v != logVersion {
+		return 0, fmt.Errorf("hashmap: unsupported log version %d", v)
+	}
+	size = int64(len(header))
+	for {
+		n, err := binary.ReadUvarint(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return size, nil
+		}
+		if err != nil || n > maxRecordLen {
+			return 0, fmt.Errorf("%w: bad record length at offset %d", ErrCorrupt, size)
+		}
+		rec := make([]byte, n+4)
+		if _, err = io.ReadFull(r, rec); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return size, nil
+		} else if err != nil {
+			return 0, err
+		}
+		body := rec[:n]
+		if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(rec[n:]) {
+			return 0, fmt.Errorf("%w: checksum mismatch at offset %d", ErrCorrupt, size)
+		}
+		if err = m.apply(body); err != nil {
+			return 0, fmt.Errorf("record at offset %d: %w", size, err)
+		}
+		size += int64(len(binary.AppendUvarint(nil, n))) + int64(len(rec))
+	}
+}
+
+// apply applies the record body to m.
+func (m *HashMap[K, V]) apply(body []byte) (err error) {
+	defer func() {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = fmt.Errorf("%w: truncated record", ErrCorrupt)
+		}
+	}()
+	r := bytes.NewReader(body)
+	op, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	key, err := readKey(r)
+	if err != nil {
+		return err
+	}
+	k, err := keyOf[K](key)
+	if err != nil {
+		return err
+	}
+	switch Op(op) {
+	case OpSet:
+		x, err := binary.ReadVarint(r)
+		if err != nil {
+			return err
+		}
+		b, err := readBytes(r)
+		if err != nil {
+			return err
+		}
+		v, err := m.codec.Decode(b)
+		if err != nil {
+			return err
+		}
+		var expire int64
+		if x != 0 {
+			if expire = x - start.UnixNano() + 1; expire <= now() {
+				m.Del(k)
+				return nil
+			}
+			m.startJanitor()
+		}
+		m.set(k, m.mustHash(k), v, expire)
+	case OpDel:
+		m.Del(k)
+	case OpLogicDel:
+		m.LogicDel(k)
+	default:
+		return fmt.Errorf("%w: unknown op %d", ErrCorrupt, op)
+	}
+	return nil
+}
+
+// appendRecord returns the log record of op on k. v, the encoded value, and
+// expire, as returned by now, are only recorded for OpSet.
+func (m *HashMap[K, V]) appendRecord(op Op, k K, v []byte, expire int64) ([]byte, error) {
+	body, err := appendKey([]byte{byte(op)}, k)
+	if err != nil {
+		return nil, err
+	}
+	if op == OpSet {
+		if expire != 0 {
+			expire += start.UnixNano() - 1
+		}
+		body = binary.AppendUvarint(binary.AppendVarint(body, expire), uint64(len(v)))
+		body = append(body, v...)
+	}
+	rec := binary.AppendUvarint(make([]byte, 0, len(body)+binary.MaxVarintLen64+4), uint64(len(body)))
+	rec = append(rec, body...)
+	return binary.LittleEndian.AppendUint32(rec, crc32.Checksum(body, crcTable)), nil
+}
+
+// encode returns the encoding of v for the log, nil if the map has no log.
+// Writers call it before modifying the map, so that if the codec fails it
+// panics with the map unchanged.
+func (m *HashMap[K, V]) encode(v V) []byte {
+	if m.oplog == nil {
+		return nil
+	}
+	b, err := m.codec.Encode(v)
+	if err != nil {
+		panic(fmt.Errorf("hashmap: cannot log value %v: %w", v, err))
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return b
+}
+
+// logOp appends the change op to e to the log, expirations as deletions, v
+// being the value encoded by encode for OpSet. It is called with the node
+// holding e locked, under the read lock of m.
+func (m *HashMap[K, V]) logOp(op Op, e *Entry[K, V], v []byte) {
+	if op == OpExpire {
+		op = OpDel
+	}
+	rec, err := m.appendRecord(op, e.k, v, atomic.LoadInt64(&e.expire))
+	if err != nil {
+		// the key was checked by hash
+		panic(err)
+	}
+	l := m.oplog
+	rewrite, err := l.write(rec)
+	if err != nil && l.policy == SyncAlways {
+		panic(fmt.Errorf("%w %s: %w", errLogFailed, l.path, err))
+	}
+	if rewrite {
+		go func() {
+			defer l.rewrites.Done()
+			err := m.rewriteLog()
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if err != nil {
+				// back off until the log doubles again
+				l.base = l.size
+			}
+			l.auto = false
+		}()
+	}
+}
+
+// write appends rec to the log and reports whether an automatic rewrite must
+// start. An I/O error is kept in l.err and stops the log; it is returned for
+// rec and every later record, which are not written.
+func (l *opLog) write(rec []byte) (rewrite bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil || l.closed {
+		return false, l.err
+	}
+	_, err = l.w.Write(rec)
+	if err == nil && l.policy == SyncAlways {
+		err = l.sync()
+	}
+	if err != nil {
+		l.err = err
+		return false, err
+	}
+	l.size += int64(len(rec))
+	if l.rewrite != nil {
+		l.rewrite.Write(rec)
+	}
+	if l.limit > 0 && !l.auto && l.size >= l.limit && l.size >= 2*l.base {
+		l.auto = true
+		l.rewrites.Add(1)
+		return true, nil
+	}
+	return false, nil
+}
+
+// sync flushes the log and syncs it as its policy requires. l.mu must be
+// held.
+func (l *opLog) sync() error {
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	if l.policy == SyncNever {
+		return nil
+	}
+	return l.f.Sync()
+}
+
+func (l *opLog) flusher() {
+	defer close(l.done)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-tick.C:
+			l.mu.Lock()
+			if l.err == nil && !l.closed {
+				l.err = l.sync()
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+func (l *opLog) close() error {
+	l.mu.Lock()
+	closed := l.closed
+	l.closed = true
+	l.mu.Unlock()
+	if closed {
+		return nil
+	}
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+	l.rewrites.Wait()
+	l.rewriteMu.Lock()
+	defer l.rewriteMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.err
+	if err == nil {
+		err = l.sync()
+	}
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// SyncLog writes the log and syncs it to stable storage, whatever its sync
+// policy. It returns the first error met writing the log, after which writes
+// are no longer logged.
+func (m *HashMap[K, V]) SyncLog() error {
+	l := m.oplog
+	if l == nil {
+		return errNoLog
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	if l.closed {
+		return errLogClosed
+	}
+	if l.err = l.w.Flush(); l.err == nil {
+		l.err = l.f.Sync()
+	}
+	return l.err
+}
+
+// LogSize returns the size of the log in bytes, including writes not synced
+// yet.
+func (m *HashMap[K, V]) LogSize() int64 {
+	l := m.oplog
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+// RewriteLog replaces the log with one holding a Set of each entry of a
+// snapshot of the map, followed by the writes made while it was written.
+// Writers go on while the log is rewritten, and the new log replaces the old
+// one atomically.
+func (m *HashMap[K, V]) RewriteLog() error {
+	if m.oplog == nil {
+		return errNoLog
+	}
+	return m.rewriteLog()
+}
+
+func (m *HashMap[K, V]) rewriteLog() (err error) {
+	l := m.oplog
+	l.rewriteMu.Lock()
+	defer l.rewriteMu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.rewrite = nil
+		l.mu.Unlock()
+	}()
+	dir := filepath.Dir(l.path)
+	f, err := os.CreateTemp(dir, filepath.Base(l.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// unless f replaced the log
+		if err != nil && l.f != f {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	// the writes made from the instant of the snapshot on are buffered, to
+	// be appended to the new log
+	s := m.snapshot(func() {
+		l.mu.Lock()
+		l.rewrite = new(bytes.Buffer)
+		l.mu.Unlock()
+	})
+	w := bufio.NewWriter(f)
+	w.WriteString(logMagic)
+	w.WriteByte(logVersion)
+	size := int64(len(logMagic) + 1)
+	for i, k := range s.keys {
+		v, err := m.codec.Encode(s.values[i])
+		if err != nil {
+			return err
+		}
+		rec, err := m.appendRecord(OpSet, k, v, s.expires[i])
+		if err != nil {
+			return err
+		}
+		w.Write(rec)
+		size += int64(len(rec))
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return errLogClosed
+	}
+	if l.err != nil {
+		return l.err
+	}
+	n, err := f.Write(l.rewrite.Bytes())
+	if err != nil {
+		return err
+	}
+	size += int64(n)
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), l.path); err != nil {
+		return err
+	}
+	// the old log is replaced, what is left in its buffer is in the new one
+	l.f.Close()
+	l.f, l.size, l.base = f, size, size
+	l.w.Reset(f)
+	return syncDir(dir)
+}
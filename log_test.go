@@ -0,0 +1,341 @@
+package hashmap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openLogged(t *testing.T, path string, opts ...Option) *HashMap[string, int] {
+	t.Helper()
+	m, err := NewWithOptions[string, int](append([]Option{WithLog(path)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func assertContents(t *testing.T, m *HashMap[string, int], want map[string]int) {
+	t.Helper()
+	assertEqual(t, m.Size(), int64(len(want)))
+	for k, v := range want {
+		if got, ok := m.Get(k); !ok || got != v {
+			t.Fatal("content err", k, got, ok)
+		}
+	}
+}
+
+func TestHashMap_LogReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	m := openLogged(t, path)
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("a", 3)
+	m.SetNX("b", 4)
+	m.SetNX("c", 5)
+	m.Del("b")
+	m.LogicDel("c")
+	m.SetWithTTL("ttl", 6, time.Hour)
+	m.SetWithTTL("gone", 7, time.Millisecond)
+	m.Set("later", 8)
+	m.Expire("later", time.Millisecond)
+	m.Compute("a", func(old int, exists bool) (int, bool) { return old * 10, true })
+	m.Store("d", 9)
+	m.Txn([]string{"e", "d"}, func(tx *Tx[string, int]) error {
+		tx.Set("e", 10)
+		tx.Del("d")
+		return nil
+	})
+	assertEqual(t, m.Close(), nil)
+	time.Sleep(5 * time.Millisecond)
+
+	l := openLogged(t, path)
+	defer l.Close()
+	assertContents(t, l, map[string]int{"a": 30, "ttl": 6, "e": 10})
+	ttl, _ := l.TTL("ttl")
+	if ttl <= 0 || ttl > time.Hour {
+		t.Fatal("ttl not replayed", ttl)
+	}
+
+	// the replayed map goes on logging
+	l.Set("f", 11)
+	assertEqual(t, l.Close(), nil)
+	r := openLogged(t, path)
+	defer r.Close()
+	assertContents(t, r, map[string]int{"a": 30, "ttl": 6, "e": 10, "f": 11})
+}
+
+func TestHashMap_LogTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	m := openLogged(t, path, WithLogSync(SyncAlways))
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Close()
+	fi, _ := os.Stat(path)
+	os.Truncate(path, fi.Size()-3)
+
+	l := openLogged(t, path)
+	assertContents(t, l, map[string]int{"a": 1})
+	l.Set("c", 3)
+	l.Close()
+	r := openLogged(t, path)
+	defer r.Close()
+	assertContents(t, r, map[string]int{"a": 1, "c": 3})
+}
+
+func TestHashMap_LogCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	m := openLogged(t, path)
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Close()
+	data, _ := os.ReadFile(path)
+	data[len(logMagic)+4] ^= 0x40
+	os.WriteFile(path, data, 0o644)
+	if _, err := NewWithOptions[string, int](WithLog(path)); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("corrupted log not detected:", err)
+	}
+
+	os.WriteFile(path, []byte("not a log"), 0o644)
+	if _, err := NewWithOptions[string, int](WithLog(path)); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("foreign file not detected:", err)
+	}
+	if _, err := NewWithOptions[string, int](WithLog(path), WithLogSync(SyncPolicy(9))); err == nil {
+		t.Fatal("accepted an invalid sync policy")
+	}
+}
+
+func TestHashMap_RewriteLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	m := openLogged(t, path, WithLogSync(SyncNever))
+	for i := 0; i < 1000; i++ {
+		m.Set(strconv.Itoa(i%10), i)
+	}
+	m.LogicDel("9")
+	m.SetWithTTL("ttl", 1, time.Hour)
+	before := m.LogSize()
+	assertEqual(t, m.RewriteLog(), nil)
+	if after := m.LogSize(); after*10 > before {
+		t.Fatal("log not compacted", before, after)
+	}
+	m.Set("0", -1)
+	m.Close()
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	assertEqual(t, len(entries), 1)
+
+	l := openLogged(t, path)
+	defer l.Close()
+	want := map[string]int{"0": -1, "ttl": 1}
+	for i := 1; i < 9; i++ {
+		want[strconv.Itoa(i)] = 990 + i
+	}
+	assertContents(t, l, want)
+	if ttl, _ := l.TTL("ttl"); ttl <= 0 {
+		t.Fatal("ttl not rewritten")
+	}
+	assertEqual(t, New[int, int]().RewriteLog(), errNoLog)
+}
+
+func TestHashMap_RewriteLogConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	m := openLogged(t, path, WithLogRewriteSize(4<<10))
+	workers, keys, rounds := 4, 100, 200
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				for k := w; k < keys; k += workers {
+					m.Set(strconv.Itoa(k), r)
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			if err := m.RewriteLog(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+	<-done
+	assertEqual(t, m.Close(), nil)
+
+	l := openLogged(t, path)
+	defer l.Close()
+	want := map[string]int{}
+	for k := 0; k < keys; k++ {
+		want[strconv.Itoa(k)] = rounds - 1
+	}
+	assertContents(t, l, want)
+}
+
+func TestHashMap_RewriteLogAuto(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	m := openLogged(t, path, WithLogRewriteSize(4<<10))
+	rewritten := false
+	for i := 0; i < 1e6 && !rewritten; i++ {
+		size := m.LogSize()
+		m.Set(strconv.Itoa(i%10), i)
+		rewritten = m.LogSize() < size
+	}
+	assertEqual(t, rewritten, true)
+	m.Set("last", 0)
+	assertEqual(t, m.Close(), nil)
+
+	l := openLogged(t, path)
+	defer l.Close()
+	assertEqual(t, l.Size(), int64(11))
+	_, ok := l.Get("last")
+	assertEqual(t, ok, true)
+}
+
+// positiveCodec fails to encode negative values.
+type positiveCodec struct{ GobCodec[int] }
+
+func (c positiveCodec) Encode(v int) ([]byte, error) {
+	if v < 0 {
+		return nil, errors.New("negative value")
+	}
+	return c.GobCodec.Encode(v)
+}
+
+func TestHashMap_LogEncodeFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	if _, err := NewWithOptions[struct{ X int }, int](WithLog(path)); err == nil {
+		t.Fatal("accepted a key type that cannot be logged")
+	}
+
+	m, err := NewWithOptions[any, int](WithLog(path), WithCodec[int](positiveCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.TrySet(struct{ X int }{}, 1); !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatal("set a key that cannot be logged:", err)
+	}
+	assertEqual(t, m.Size(), int64(0))
+	// a write that cannot be logged panics and leaves the map unchanged
+	mustPanic := func(fn func()) {
+		t.Helper()
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected panic")
+				}
+			}()
+			fn()
+		}()
+		assertEqual(t, m.Size(), int64(0))
+		if _, ok := m.Get("neg"); ok {
+			t.Fatal("unlogged write applied")
+		}
+	}
+	mustPanic(func() { m.Set("neg", -1) })
+	mustPanic(func() { m.SetNX("neg", -1) })
+	mustPanic(func() { m.Swap("neg", -1) })
+	mustPanic(func() { m.MSet([]any{"neg"}, []int{-1}) })
+	mustPanic(func() {
+		m.Compute("neg", func(int, bool) (int, bool) { return -1, true })
+	})
+	mustPanic(func() {
+		m.Txn([]any{"pos", "neg"}, func(tx *Tx[any, int]) error {
+			tx.Set("pos", 1)
+			tx.Set("neg", -1)
+			return nil
+		})
+	})
+	_, ok := m.Get("pos")
+	assertEqual(t, ok, false)
+	// nor is an existing value replaced
+	m.Set("a", 1)
+	for _, set := range []func(){
+		func() { m.Set("a", -1) },
+		func() { m.MSet([]any{"a"}, []int{-1}) },
+		func() { m.CompareAndSwap("a", 1, -1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected panic")
+				}
+			}()
+			set()
+		}()
+		v, _ := m.Get("a")
+		assertEqual(t, v, 1)
+	}
+	assertEqual(t, m.Size(), int64(1))
+	// the log goes on after the failures, with the locks released
+	m.MSet([]any{"b", 2}, []int{2, 2})
+	assertEqual(t, m.Size(), int64(3))
+	assertEqual(t, m.Close(), nil)
+
+	l, err := NewWithOptions[any, int](WithLog(path), WithCodec[int](positiveCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	assertEqual(t, l.Size(), int64(3))
+	for _, k := range []any{"a", "b", 2} {
+		if _, ok := l.Get(k); !ok {
+			t.Fatal("write not logged", k)
+		}
+	}
+}
+
+func TestHashMap_LogWriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	m := openLogged(t, path, WithLogSync(SyncAlways), WithJanitorInterval(time.Millisecond))
+	m.Set("a", 1)
+	m.SetWithTTL("ttl", 2, 20*time.Millisecond)
+	m.oplog.f.Close()
+	// a write the log fails to record panics once applied, and so does
+	// every later write
+	mustPanic := func(fn func()) {
+		t.Helper()
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, errLogFailed) {
+				t.Fatal("expected log failure, got", err)
+			}
+		}()
+		fn()
+	}
+	mustPanic(func() { m.Set("b", 3) })
+	mustPanic(func() { m.Set("a", 4) })
+	mustPanic(func() { m.Del("b") })
+	mustPanic(func() {
+		m.Txn([]string{"c", "d"}, func(tx *Tx[string, int]) error {
+			tx.Set("c", 5)
+			tx.Set("d", 6)
+			return nil
+		})
+	})
+	// the janitor goes on, with the locks released
+	time.Sleep(50 * time.Millisecond)
+	assertContents(t, m, map[string]int{"a": 4, "c": 5, "d": 6})
+	if m.SyncLog() == nil || m.Close() == nil {
+		t.Fatal("log failure not reported")
+	}
+
+	// other policies do not tell writers
+	l := openLogged(t, path, WithLogSync(SyncNever))
+	assertContents(t, l, map[string]int{"a": 1})
+	l.oplog.f.Close()
+	l.Set("b", 2)
+	assertEqual(t, l.SyncLog() != nil, true)
+	l.Set("c", 3)
+	assertContents(t, l, map[string]int{"a": 1, "b": 2, "c": 3})
+	l.Close()
+
+	r := openLogged(t, path)
+	defer r.Close()
+	assertContents(t, r, map[string]int{"a": 1})
+}
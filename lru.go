@@ -0,0 +1,137 @@
+package hashmap
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// evictSamples is the number of entries compared to pick an eviction victim.
+// Sampling approximates LRU without a shared recency list, so that Get only
+// has to store a timestamp in the entry it returns.
+const evictSamples = 16
+
+// evictProbes bounds the nodes read to sample a victim, so that eviction
+// stays cheap in a sparse table.
+const evictProbes = 4 * evictSamples
+
+// maxEvictAttempts bounds the victims tried per insertion when concurrent
+// writers keep removing the sampled ones first.
+const maxEvictAttempts = 8
+
+// WithMaxEntries bounds the map to n entries. Inserting a new key into a
+// full map evicts an entry chosen by the eviction policy, by default the
+// approximately least recently used one.
+//
+// Victims are sampled from a bounded number of nodes, so WithCapacity is
+// capped at the nodes n entries need at the load factor: a sparser table
+// would often have nothing to evict in the nodes sampled.
+func WithMaxEntries(n int) Option {
+	return func(c *config) {
+		c.maxEntries = &n
+	}
+}
+
+// WithEvictCallback sets a function called with the key and value of every
+// entry evicted to respect WithMaxEntries, including new keys rejected by
+// EvictTinyLFU. It is called without any lock held, from the goroutine whose
+// insertion caused the eviction.
+func WithEvictCallback[K comparable, V any](fn func(K, V)) Option {
+	return func(c *config) {
+		c.onEvict = fn
+	}
+}
+
+// NewLRU returns a map holding at most maxEntries entries, evicting the
+// approximately least recently used entry when a new key is inserted into a
+// full map. Both Get and Set count as a use.
+func NewLRU[K comparable, V any](maxEntries int, opts ...Option) (*HashMap[K, V], error) {
+	return NewWithOptions[K, V](append(opts, WithMaxEntries(maxEntries))...)
+}
+
+// MaxEntries returns the number of entries the map is bounded to, 0 if it
+// is unbounded.
+func (m *HashMap[K, V]) MaxEntries() int {
+	return int(m.maxEntries)
+}
+
+// touch records an access to e when the map is bounded.
+func (m *HashMap[K, V]) touch(e *Entry[K, V]) {
+	if m.maxEntries > 0 {
+		atomic.StoreInt64(&e.access, now())
+	}
+}
+
+// evict removes entries until the map is back within its bound, after
+// candidate has been inserted.
+func (m *HashMap[K, V]) evict(candidate *Entry[K, V]) {
+	for i := 0; i < maxEvictAttempts && atomic.LoadInt64(&m.size) > m.maxEntries; i++ {
+		victim := m.sampleVictim(candidate)
+		if victim == nil {
+			// sample other nodes
+			continue
+		}
+		if candidate != nil && !victim.expired() && !m.admit(candidate, victim) {
+			victim = candidate
+		}
+		candidate = nil
+		if m.removeEntry(victim) && m.onEvict != nil {
+			m.onEvict(victim.k, victim.Value())
+		}
+	}
+}
+
+// sampleVictim returns the entry to evict among evictSamples live entries
+// read from at most evictProbes consecutive nodes, starting at a random one,
+// skipping the candidate just inserted. Expired entries are taken first. It
+// returns nil if those nodes hold no other live entry.
+func (m *HashMap[K, V]) sampleVictim(candidate *Entry[K, V]) (victim *Entry[K, V]) {
+	h := rand.Uint64()
+	probes := min(m.table.Load().len(), evictProbes)
+	sampled := 0
+	for i := 0; i < probes && sampled < evictSamples; i++ {
+		t, n := m.chain(h + uint64(i))
+		for e := n.head.Load(); e != nil && sampled < evictSamples; e = e.next[t.ab].Load() {
+			if e == candidate || atomic.LoadInt32(&e.flag) != 0 {
+				continue
+			}
+			if e.expired() {
+				return e
+			}
+			if victim == nil || m.colder(e, victim) {
+				victim = e
+			}
+			sampled++
+		}
+	}
+	return
+}
+
+// colder reports whether a should be evicted before b: the less frequently
+// used under EvictTinyLFU, then the less recently used.
+func (m *HashMap[K, V]) colder(a, b *Entry[K, V]) bool {
+	if m.sketch != nil {
+		if fa, fb := m.sketch.estimate(a.hash), m.sketch.estimate(b.hash); fa != fb {
+			return fa < fb
+		}
+	}
+	return atomic.LoadInt64(&a.access) < atomic.LoadInt64(&b.access)
+}
+
+// removeEntry removes e if it is still linked into the map, reporting
+// whether it did.
+func (m *HashMap[K, V]) removeEntry(e *Entry[K, V]) bool {
+	m.RLock()
+	defer m.RUnlock()
+	t, n := m.lockNode(e.hash)
+	defer n.Unlock()
+	for next := n.head.Load(); next != nil; next = next.next[t.ab].Load() {
+		if next == e {
+			if atomic.LoadInt32(&e.flag) != 0 {
+				return false
+			}
+			m.remove(t, n, e)
+			return true
+		}
+	}
+	return false
+}
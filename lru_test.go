@@ -0,0 +1,109 @@
+package hashmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewLRU(t *testing.T) {
+	evicted := map[int]int{}
+	m, err := NewLRU[int, int](1000, WithEvictCallback(func(k, v int) {
+		if k != v {
+			t.Error("evicted", k, v)
+		}
+		evicted[k]++
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, m.MaxEntries(), 1000)
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	assertEqual(t, len(evicted), 0)
+	// keys 0-499 are used again and should outlive 500-999
+	for i := 0; i < 500; i++ {
+		m.Get(i)
+	}
+	for i := 1000; i < 1500; i++ {
+		m.Set(i, i)
+	}
+	assertEqual(t, m.Size(), int64(1000))
+	assertEqual(t, len(evicted), 500)
+	hot, cold := 0, 0
+	for k, n := range evicted {
+		if n != 1 {
+			t.Fatal("evicted twice", k)
+		}
+		if _, ok := m.Get(k); ok {
+			t.Fatal("evicted key still present", k)
+		}
+		if k < 500 {
+			hot++
+		} else if k < 1000 {
+			cold++
+		}
+	}
+	if hot > cold/2 {
+		t.Fatalf("evicted %d recently used and %d unused keys", hot, cold)
+	}
+}
+
+func TestNewLRU_Concurrent(t *testing.T) {
+	evicted := int64(0)
+	m, err := NewLRU[int, int](1000, WithEvictCallback(func(k, v int) {
+		atomic.AddInt64(&evicted, 1)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(base int) {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				m.Set(base+i, i)
+				m.Get(base + i/2)
+			}
+		}(w * 10000)
+	}
+	wg.Wait()
+	if m.Size() > 1000+8 {
+		t.Fatal("size over bound", m.Size())
+	}
+	assertEqual(t, evicted+m.Size(), int64(80000))
+}
+
+func TestNewLRU_Sparse(t *testing.T) {
+	for _, capacity := range []int{1 << 16, 1 << 20} {
+		m, err := NewLRU[int, int](100, WithCapacity(capacity))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the capacity is capped so that probing a bounded number of nodes
+		// finds victims
+		assertEqual(t, m.table.Load().len(), 64)
+		for i := 0; i < 20000; i++ {
+			m.Set(i, i)
+			if m.Size() > int64(m.MaxEntries()) {
+				t.Fatal("sparse map over bound", capacity, i, m.Size())
+			}
+		}
+	}
+	m, _ := NewLRU[int, int](1000, WithCapacity(64))
+	assertEqual(t, m.table.Load().len(), 64)
+}
+
+func TestNewLRU_Invalid(t *testing.T) {
+	if _, err := NewLRU[int, int](0); err == nil {
+		t.Fatal("expected max entries error")
+	}
+	if _, err := NewWithOptions[int, int](WithEvictCallback(func(k, v int) {})); err == nil {
+		t.Fatal("expected unbounded callback error")
+	}
+	if _, err := NewLRU[int, int](10, WithEvictCallback(func(k string, v int) {})); err == nil {
+		t.Fatal("expected callback type error")
+	}
+}
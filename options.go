@@ -0,0 +1,242 @@
+package hashmap
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"reflect"
+	"time"
+)
+
+// Option configures a HashMap created by NewWithOptions.
+type Option func(*config)
+
+type config struct {
+	capacity   int
+	loadFactor float64
+	maxCap     int
+	hasher     any
+	seeded     bool
+	lowWater   *float64
+
+	janitorInterval time.Duration
+
+	maxEntries *int
+	onEvict    any
+	policy     EvictionPolicy
+
+	purgeTombstones bool
+
+	watchBuffer int
+	watchPolicy WatchPolicy
+
+	codec any
+
+	logPath    string
+	logSync    SyncPolicy
+	logRewrite int64
+
+	typedJSON bool
+	jsonKey   reflect.Type
+}
+
+// WithCapacity sets the initial number of nodes, rounded up to a power of
+// two. Sizing the table for the expected number of entries divided by the
+// load factor avoids resizing during a bulk load. The table never shrinks
+// below its initial capacity. With WithMaxEntries, n is capped at the nodes
+// the maximum number of entries needs.
+func WithCapacity(n int) Option {
+	return func(c *config) {
+		c.capacity = n
+	}
+}
+
+// WithLoadFactor sets the average number of entries per node above which the
+// table doubles.
+func WithLoadFactor(f float64) Option {
+	return func(c *config) {
+		c.loadFactor = f
+	}
+}
+
+// WithMaxCapacity caps the number of nodes the table may grow to, rounded
+// down to a power of two. Once it is reached the table stops growing and
+// chains get longer instead.
+func WithMaxCapacity(n int) Option {
+	return func(c *config) {
+		c.maxCap = n
+	}
+}
+
+// WithHasher sets the function used to hash keys. It takes precedence over
+// both the built-in key types and keys implementing Hasher.
+func WithHasher[K comparable](fn func(K) uint64) Option {
+	return func(c *config) {
+		c.hasher = fn
+	}
+}
+
+// WithRandomSeed hashes string keys with hash/maphash under a seed chosen
+// randomly for each map, so that keys controlled by an attacker cannot be
+// crafted to collide into a single bucket. Without it string keys use an
+// unseeded FNV hash.
+func WithRandomSeed() Option {
+	return func(c *config) {
+		c.seeded = true
+	}
+}
+
+// WithLowWaterMark sets the average number of entries per node below which
+// the table is halved after deletions. It must be less than half the load
+// factor so that a shrunk table does not immediately grow again; 0 disables
+// shrinking. It defaults to a quarter of the load factor.
+func WithLowWaterMark(lw float64) Option {
+	return func(c *config) {
+		c.lowWater = &lw
+	}
+}
+
+// NewWithOptions returns a map configured by opts.
+func NewWithOptions[K comparable, V any](opts ...Option) (*HashMap[K, V], error) {
+	c := &config{capacity: defaultCapacity, loadFactor: defaultLoadFactor, maxCap: MaxInt, janitorInterval: defaultJanitorInterval, watchBuffer: defaultWatchBuffer, logRewrite: defaultLogRewriteSize}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.loadFactor <= 0 || math.IsInf(c.loadFactor, 0) || math.IsNaN(c.loadFactor) {
+		return nil, fmt.Errorf("hashmap: invalid load factor %v", c.loadFactor)
+	}
+	if c.maxCap < 1 || c.maxCap > MaxInt {
+		return nil, fmt.Errorf("hashmap: max capacity %d outside [1, %d]", c.maxCap, MaxInt)
+	}
+	// the table doubles, so it can only reach a power of two
+	maxCap := 1 << (bits.Len(uint(c.maxCap)) - 1)
+	if c.capacity < 1 || c.capacity > maxCap {
+		return nil, fmt.Errorf("hashmap: capacity %d outside [1, %d]", c.capacity, maxCap)
+	}
+	if c.janitorInterval <= 0 {
+		return nil, fmt.Errorf("hashmap: invalid janitor interval %v", c.janitorInterval)
+	}
+	capacity := 1 << bits.Len(uint(c.capacity-1))
+	if capacity > maxCap {
+		return nil, fmt.Errorf("hashmap: capacity %d rounds up to %d, over max capacity %d", c.capacity, capacity, maxCap)
+	}
+	// victims are sampled from a bounded number of nodes, so a bounded map
+	// starts no sparser than its entries need
+	if c.maxEntries != nil && *c.maxEntries > 0 {
+		if fit := math.Ceil(float64(*c.maxEntries) / c.loadFactor); fit < float64(capacity) {
+			capacity = 1 << bits.Len(uint(fit)-1)
+		}
+	}
+	m := newHashMap[K, V](capacity, c.loadFactor, maxCap)
+	m.janitor.interval = c.janitorInterval
+	if c.hasher != nil {
+		fn, ok := c.hasher.(func(K) uint64)
+		if !ok {
+			return nil, fmt.Errorf("hashmap: hasher %T does not match key type %T", c.hasher, *new(K))
+		}
+		if fn == nil {
+			return nil, fmt.Errorf("hashmap: nil hasher")
+		}
+		m.hasher = fn
+	}
+	if c.lowWater != nil {
+		if lw := *c.lowWater; lw < 0 || lw >= m.loadFactor/2 {
+			return nil, fmt.Errorf("hashmap: low-water mark %v outside [0, %v)", lw, m.loadFactor/2)
+		}
+		m.setLowWater(*c.lowWater)
+	}
+	if c.maxEntries != nil {
+		if *c.maxEntries < 1 {
+			return nil, fmt.Errorf("hashmap: max entries %d must be positive", *c.maxEntries)
+		}
+		m.maxEntries = int64(*c.maxEntries)
+	}
+	if c.onEvict != nil {
+		fn, ok := c.onEvict.(func(K, V))
+		if !ok {
+			return nil, fmt.Errorf("hashmap: evict callback %T does not match map type %T", c.onEvict, m)
+		}
+		if m.maxEntries == 0 {
+			return nil, fmt.Errorf("hashmap: evict callback set on an unbounded map")
+		}
+		m.onEvict = fn
+	}
+	switch c.policy {
+	case EvictLRU:
+	case EvictTinyLFU:
+		if m.maxEntries == 0 {
+			return nil, fmt.Errorf("hashmap: eviction policy %v set on an unbounded map", c.policy)
+		}
+		m.sketch = newSketch(int(m.maxEntries))
+	default:
+		return nil, fmt.Errorf("hashmap: invalid eviction policy %v", c.policy)
+	}
+	m.purge = c.purgeTombstones
+	if c.watchBuffer < 1 {
+		return nil, fmt.Errorf("hashmap: watch buffer %d must be positive", c.watchBuffer)
+	}
+	if c.watchPolicy != WatchDrop && c.watchPolicy != WatchClose {
+		return nil, fmt.Errorf("hashmap: invalid watch policy %d", c.watchPolicy)
+	}
+	m.watchBuf, m.onFull = c.watchBuffer, c.watchPolicy
+	if c.codec != nil {
+		codec, ok := c.codec.(Codec[V])
+		if !ok {
+			return nil, fmt.Errorf("hashmap: codec %T does not match value type %T", c.codec, *new(V))
+		}
+		m.codec = codec
+	}
+	if c.seeded {
+		seed := maphash.MakeSeed()
+		m.seed = &seed
+	}
+	if c.jsonKey != nil {
+		if _, ok := keyTag(c.jsonKey); !ok || !c.jsonKey.AssignableTo(reflect.TypeFor[K]()) {
+			return nil, fmt.Errorf("hashmap: JSON key type %v not supported for key type %v", c.jsonKey, reflect.TypeFor[K]())
+		}
+		m.jsonKey = c.jsonKey
+	}
+	m.typedJSON = c.typedJSON
+	if c.logPath != "" {
+		if c.logSync != SyncEverySecond && c.logSync != SyncAlways && c.logSync != SyncNever {
+			return nil, fmt.Errorf("hashmap: invalid log sync policy %d", c.logSync)
+		}
+		if c.logRewrite < 0 {
+			return nil, fmt.Errorf("hashmap: log rewrite size %d must not be negative", c.logRewrite)
+		}
+		// keys of interface types are checked as they are written
+		if t := reflect.TypeFor[K](); t.Kind() != reflect.Interface {
+			if _, ok := keyTag(t); !ok {
+				return nil, fmt.Errorf("hashmap: key type %v cannot be logged", t)
+			}
+		}
+		if err := m.openLog(c.logPath, c.logSync, c.logRewrite); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Capacity returns the number of nodes in the table.
+func (m *HashMap[K, V]) Capacity() int {
+	return m.table.Load().len()
+}
+
+// LoadFactor returns the average number of entries per node above which the
+// table grows.
+func (m *HashMap[K, V]) LoadFactor() float64 {
+	return m.loadFactor
+}
+
+// LowWaterMark returns the average number of entries per node below which
+// the table shrinks.
+func (m *HashMap[K, V]) LowWaterMark() float64 {
+	return m.lowWater
+}
+
+// MaxCapacity returns the number of nodes the table may grow to.
+func (m *HashMap[K, V]) MaxCapacity() int {
+	return m.maxCap
+}
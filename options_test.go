@@ -0,0 +1,189 @@
+package hashmap
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+type tenantKey struct {
+	Tenant, ID string
+}
+
+type hashedKey struct {
+	id int
+}
+
+func (k hashedKey) HashKey() uint64 {
+	return uint64(k.id)
+}
+
+func TestHashMap_StructKey(t *testing.T) {
+	m := New[tenantKey, int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(tenantKey{"t", string(rune('a' + i%26))}, i)
+	}
+	assertEqual(t, m.Size(), int64(26))
+	v, ok := m.Get(tenantKey{"t", "b"})
+	assertEqual(t, ok, true)
+	assertEqual(t, v, 989)
+
+	a := NewAny()
+	a.Set(tenantKey{"t", "a"}, 1)
+	a.Set(&hashedKey{1}, 2)
+	a.Set([2]int{1, 2}, 3)
+	v2, ok := a.Get(tenantKey{"t", "a"})
+	assertEqual(t, ok, true)
+	assertEqual(t, v2, 1)
+	v2, ok = a.Get([2]int{1, 2})
+	assertEqual(t, ok, true)
+	assertEqual(t, v2, 3)
+}
+
+func TestHashMap_HasherKey(t *testing.T) {
+	m := New[hashedKey, int]()
+	for i := 0; i < 100; i++ {
+		m.Set(hashedKey{i}, i)
+	}
+	for i := 0; i < 100; i++ {
+		v, ok := m.Get(hashedKey{i})
+		if !ok || v != i {
+			t.Fatal("data err ", i)
+		}
+	}
+}
+
+func TestNewWithOptions_Hasher(t *testing.T) {
+	calls := 0
+	m, err := NewWithOptions[tenantKey, int](WithHasher(func(k tenantKey) uint64 {
+		calls++
+		return uint64(len(k.Tenant) + len(k.ID))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Set(tenantKey{"a", "b"}, 1)
+	m.Set(tenantKey{"b", "a"}, 2)
+	v, ok := m.Get(tenantKey{"b", "a"})
+	assertEqual(t, ok, true)
+	assertEqual(t, v, 2)
+	if calls == 0 {
+		t.Fatal("hasher not used")
+	}
+
+	if _, err = NewWithOptions[string, int](WithHasher(func(k int) uint64 { return 0 })); err == nil {
+		t.Fatal("expected hasher type error")
+	}
+	if _, err = NewWithOptions[string, int](WithHasher[string](nil)); err == nil {
+		t.Fatal("expected nil hasher error")
+	}
+}
+
+func maxBucketSize[K comparable, V any](m *HashMap[K, V]) (max int64) {
+	for i := range m.table.Load().nodes {
+		if n := m.table.Load().nodes[i].size; n > max {
+			max = n
+		}
+	}
+	return
+}
+
+func TestNewWithOptions_RandomSeed(t *testing.T) {
+	// keys whose unseeded hash collides in the low 8 bits, landing in the
+	// same bucket for every table of up to 256 buckets
+	var keys []string
+	for i := 0; len(keys) < 256; i++ {
+		k := strconv.Itoa(i)
+		if bytesHash([]byte(k))&0xff == 0 {
+			keys = append(keys, k)
+		}
+	}
+
+	plain := New[string, int]()
+	seeded, err := NewWithOptions[string, int](WithRandomSeed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, k := range keys {
+		plain.Set(k, i)
+		seeded.Set(k, i)
+	}
+	assertEqual(t, maxBucketSize(plain), int64(len(keys)))
+	if max := maxBucketSize(seeded); max > 16 {
+		t.Fatalf("seeded map has a bucket of %d entries", max)
+	}
+	for i, k := range keys {
+		v, ok := seeded.Get(k)
+		if !ok || v != i {
+			t.Fatal("data err ", k)
+		}
+	}
+}
+
+func TestNewWithOptions_LowWaterMark(t *testing.T) {
+	for _, lw := range []float64{-1, 1.05, 3} {
+		if _, err := NewWithOptions[int, int](WithLowWaterMark(lw)); err == nil {
+			t.Fatal("expected low-water mark error for", lw)
+		}
+	}
+	m, err := NewWithOptions[int, int](WithLowWaterMark(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, m.lowWater, 0.5)
+}
+
+func TestNewWithOptions_Capacity(t *testing.T) {
+	m, err := NewWithOptions[int, int](WithCapacity(1000), WithLoadFactor(1), WithMaxCapacity(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, m.Capacity(), 1024)
+	assertEqual(t, m.LoadFactor(), 1.0)
+	assertEqual(t, m.LowWaterMark(), 0.25)
+	assertEqual(t, m.MaxCapacity(), 4096)
+
+	for i := 0; i < 1024; i++ {
+		m.Set(i, i)
+	}
+	assertEqual(t, m.Capacity(), 1024)
+	for i := 1024; i < 100000; i++ {
+		m.Set(i, i)
+	}
+	assertEqual(t, m.Capacity(), 4096)
+	for i := 0; i < 100000; i++ {
+		m.Del(i)
+	}
+	assertEqual(t, m.Capacity(), 1024)
+
+	d := NewAny()
+	assertEqual(t, d.Capacity(), defaultCapacity)
+	assertEqual(t, d.LoadFactor(), defaultLoadFactor)
+	assertEqual(t, d.MaxCapacity(), maxCapacity)
+
+	r, err := NewWithOptions[int, int](WithMaxCapacity(5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, r.MaxCapacity(), 4096)
+}
+
+func TestNewWithOptions_Invalid(t *testing.T) {
+	invalid := [][]Option{
+		{WithCapacity(0)},
+		{WithCapacity(-1)},
+		{WithCapacity(100), WithMaxCapacity(64)},
+		{WithCapacity(100), WithMaxCapacity(100)},
+		{WithMaxCapacity(0)},
+		{WithLoadFactor(0)},
+		{WithLoadFactor(-1)},
+		{WithLoadFactor(math.Inf(1))},
+		{WithLoadFactor(math.NaN())},
+		{WithLoadFactor(1), WithLowWaterMark(0.5)},
+	}
+	for i, opts := range invalid {
+		if _, err := NewWithOptions[int, int](opts...); err == nil {
+			t.Fatal("expected error for options", i)
+		}
+	}
+}
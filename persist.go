@@ -0,0 +1,515 @@
+package hashmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// ErrCorrupt is returned when loading data that is truncated, fails its
+// checksum or goes on after it. Nothing is loaded into the map in that case.
+var ErrCorrupt = errors.New("hashmap: corrupted data")
+
+// Codec encodes the values of a map for SaveTo and LoadFrom.
+type Codec[V any] interface {
+	Encode(v V) ([]byte, error)
+	Decode(b []byte) (V, error)
+}
+
+// GobCodec encodes values with encoding/gob. It is the default codec. When V
+// is an interface type, the concrete types stored in it other than the
+// predeclared ones must be registered with gob.Register.
+type GobCodec[V any] struct{}
+
+func (GobCodec[V]) Encode(v V) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(&v)
+	return b.Bytes(), err
+}
+
+func (GobCodec[V]) Decode(b []byte) (v V, err error) {
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return
+}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec[V any] struct{}
+
+func (JSONCodec[V]) Encode(v V) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[V]) Decode(b []byte) (v V, err error) {
+	err = json.Unmarshal(b, &v)
+	return
+}
+
+// WithCodec sets the codec used by SaveTo and LoadFrom to encode values.
+func WithCodec[V any](c Codec[V]) Option {
+	return func(cfg *config) {
+		cfg.codec = c
+	}
+}
+
+// The binary format starts with snapshotMagic and snapshotVersion, followed
+// by the number of entries as a uvarint and the entries, each a key tag, the
+// key and the length-prefixed encoded value. A CRC-32C of everything before
+// it ends the data.
+const (
+	snapshotMagic   = "HMAP"
+	snapshotVersion = 1
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Key tags identify the type of a key, among those hashed natively by the
+// hash function.
+const (
+	tagNil byte = iota
+	tagString
+	tagBool
+	tagTime
+	tagInt
+	tagInt8
+	tagInt16
+	tagInt32
+	tagInt64
+	tagUint
+	tagUint8
+	tagUint16
+	tagUint32
+	tagUint64
+	tagFloat32
+	tagFloat64
+	tagUintptr
+)
+
+// tagTypes holds the key type of each tag.
+var tagTypes = [...]reflect.Type{
+	tagString:  reflect.TypeFor[string](),
+	tagBool:    reflect.TypeFor[bool](),
+	tagTime:    reflect.TypeFor[time.Time](),
+	tagInt:     reflect.TypeFor[int](),
+	tagInt8:    reflect.TypeFor[int8](),
+	tagInt16:   reflect.TypeFor[int16](),
+	tagInt32:   reflect.TypeFor[int32](),
+	tagInt64:   reflect.TypeFor[int64](),
+	tagUint:    reflect.TypeFor[uint](),
+	tagUint8:   reflect.TypeFor[uint8](),
+	tagUint16:  reflect.TypeFor[uint16](),
+	tagUint32:  reflect.TypeFor[uint32](),
+	tagUint64:  reflect.TypeFor[uint64](),
+	tagFloat32: reflect.TypeFor[float32](),
+	tagFloat64: reflect.TypeFor[float64](),
+	tagUintptr: reflect.TypeFor[uintptr](),
+}
+
+// keyTag returns the tag of keys of type t, which is one of tagTypes or a
+// type defined over one of their kinds, such as type ID string. ok is false
+// if appendKey cannot encode keys of type t.
+func keyTag(t reflect.Type) (tag byte, ok bool) {
+	for i, tt := range tagTypes {
+		if tt != nil && (t == tt || byte(i) != tagTime && t.Kind() == tt.Kind()) {
+			return byte(i), true
+		}
+	}
+	return 0, false
+}
+
+// appendKey appends the tag and encoding of k to b. A key of a type defined
+// over one of the kinds of tagTypes is encoded as a key of that type.
+func appendKey(b []byte, k any) ([]byte, error) {
+	switch k := k.(type) {
+	case nil:
+		return append(b, tagNil), nil
+	case string:
+		b = binary.AppendUvarint(append(b, tagString), uint64(len(k)))
+		return append(b, k...), nil
+	case bool:
+		if k {
+			return append(b, tagBool, 1), nil
+		}
+		return append(b, tagBool, 0), nil
+	case time.Time:
+		t, err := k.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = binary.AppendUvarint(append(b, tagTime), uint64(len(t)))
+		return append(b, t...), nil
+	case int:
+		return binary.AppendVarint(append(b, tagInt), int64(k)), nil
+	case int8:
+		return binary.AppendVarint(append(b, tagInt8), int64(k)), nil
+	case int16:
+		return binary.AppendVarint(append(b, tagInt16), int64(k)), nil
+	case int32:
+		return binary.AppendVarint(append(b, tagInt32), int64(k)), nil
+	case int64:
+		return binary.AppendVarint(append(b, tagInt64), k), nil
+	case uint:
+		return binary.AppendUvarint(append(b, tagUint), uint64(k)), nil
+	case uint8:
+		return binary.AppendUvarint(append(b, tagUint8), uint64(k)), nil
+	case uint16:
+		return binary.AppendUvarint(append(b, tagUint16), uint64(k)), nil
+	case uint32:
+		return binary.AppendUvarint(append(b, tagUint32), uint64(k)), nil
+	case uint64:
+		return binary.AppendUvarint(append(b, tagUint64), k), nil
+	case float32:
+		return binary.LittleEndian.AppendUint32(append(b, tagFloat32), math.Float32bits(k)), nil
+	case float64:
+		return binary.LittleEndian.AppendUint64(append(b, tagFloat64), math.Float64bits(k)), nil
+	case uintptr:
+		return binary.AppendUvarint(append(b, tagUintptr), uint64(k)), nil
+	}
+	v := reflect.ValueOf(k)
+	if tag, ok := keyTag(v.Type()); ok && tag != tagTime {
+		return appendKey(b, v.Convert(tagTypes[tag]).Interface())
+	}
+	return nil, fmt.Errorf("hashmap: cannot encode key of type %T", k)
+}
+
+// byteReader is the reader the decoding functions read from.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// readKey reads a key written by appendKey.
+func readKey(r byteReader) (any, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case tagNil:
+		return nil, nil
+	case tagString:
+		b, err := readBytes(r)
+		return string(b), err
+	case tagBool:
+		b, err := r.ReadByte()
+		return b != 0, err
+	case tagTime:
+		b, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		var t time.Time
+		if err = t.UnmarshalBinary(b); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		return t, nil
+	case tagInt, tagInt8, tagInt16, tagInt32, tagInt64:
+		i, err := binary.ReadVarint(r)
+		return intKey(tag, i), err
+	case tagUint, tagUint8, tagUint16, tagUint32, tagUint64, tagUintptr:
+		u, err := binary.ReadUvarint(r)
+		return uintKey(tag, u), err
+	case tagFloat32:
+		var b [4]byte
+		_, err := io.ReadFull(r, b[:])
+		return math.Float32frombits(binary.LittleEndian.Uint32(b[:])), err
+	case tagFloat64:
+		var b [8]byte
+		_, err := io.ReadFull(r, b[:])
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), err
+	}
+	return nil, fmt.Errorf("%w: unknown key tag %d", ErrCorrupt, tag)
+}
+
+// intKey converts i to the signed integer type of tag.
+func intKey(tag byte, i int64) any {
+	switch tag {
+	case tagInt:
+		return int(i)
+	case tagInt8:
+		return int8(i)
+	case tagInt16:
+		return int16(i)
+	case tagInt32:
+		return int32(i)
+	}
+	return i
+}
+
+// uintKey converts u to the unsigned integer type of tag.
+func uintKey(tag byte, u uint64) any {
+	switch tag {
+	case tagUint:
+		return uint(u)
+	case tagUint8:
+		return uint8(u)
+	case tagUint16:
+		return uint16(u)
+	case tagUint32:
+		return uint32(u)
+	case tagUintptr:
+		return uintptr(u)
+	}
+	return u
+}
+
+// maxRecordLen bounds the length prefixes read, so that a corrupted length
+// is rejected rather than allocated.
+const maxRecordLen = 1 << 30
+
+func readBytes(r byteReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxRecordLen {
+		return nil, fmt.Errorf("%w: length %d", ErrCorrupt, n)
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+// keyOf converts a decoded key to K, which may be a type defined over the
+// kind of the key. A nil key converts to the nil K of interface key types.
+func keyOf[K comparable](k any) (K, error) {
+	key, ok := k.(K)
+	if ok || k == nil && any(key) == nil {
+		return key, nil
+	}
+	t := reflect.TypeFor[K]()
+	if _, ok = keyTag(t); ok && k != nil && reflect.TypeOf(k).Kind() == t.Kind() && reflect.TypeOf(k).ConvertibleTo(t) {
+		return reflect.ValueOf(k).Convert(t).Interface().(K), nil
+	}
+	return key, fmt.Errorf("hashmap: cannot load key of type %T into %v", k, t)
+}
+
+// SaveTo writes the entries of a snapshot of the map to w, in a binary format
+// that LoadFrom reads back. Keys must be of one of the types hashed natively,
+// such as strings, integers, floats, bools and time.Time, or of a type
+// defined over one of them other than time.Time; values are encoded with the
+// codec set by WithCodec. Expirations are not saved. Keys of a defined type,
+// such as type ID string, are saved as their underlying type, which is the
+// type they are loaded as into a map with interface keys.
+func (m *HashMap[K, V]) SaveTo(w io.Writer) error {
+	s := m.Snapshot()
+	crc := crc32.New(crcTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	b := binary.AppendUvarint(append([]byte(snapshotMagic), snapshotVersion), uint64(s.Size()))
+	if _, err := bw.Write(b); err != nil {
+		return err
+	}
+	for i, k := range s.keys {
+		b, err := appendKey(b[:0], k)
+		if err != nil {
+			return err
+		}
+		v, err := m.codec.Encode(s.values[i])
+		if err != nil {
+			return err
+		}
+		b = binary.AppendUvarint(b, uint64(len(v)))
+		if _, err = bw.Write(append(b, v...)); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	_, err := w.Write(binary.LittleEndian.AppendUint32(nil, crc.Sum32()))
+	return err
+}
+
+// crcReader checksums the bytes read through it.
+type crcReader struct {
+	r   *bufio.Reader
+	crc uint32
+}
+
+func (r *crcReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc = crc32.Update(r.crc, crcTable, p[:n])
+	return n, err
+}
+
+func (r *crcReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.crc = crc32.Update(r.crc, crcTable, []byte{b})
+	}
+	return b, err
+}
+
+// LoadFrom reads entries written by SaveTo and sets them in the map,
+// replacing the values of keys already present. The whole input is read and
+// checked before the map is modified: if it is corrupted, an error wrapping
+// ErrCorrupt is returned and the map is left unchanged.
+func (m *HashMap[K, V]) LoadFrom(r io.Reader) error {
+	ks, vs, err := m.decode(r)
+	if err != nil {
+		return err
+	}
+	return m.MSet(ks, vs)
+}
+
+func (m *HashMap[K, V]) decode(r io.Reader) (ks []K, vs []V, err error) {
+	cr := &crcReader{r: bufio.NewReader(r)}
+	defer func() {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = fmt.Errorf("%w: truncated", ErrCorrupt)
+		}
+	}()
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err = io.ReadFull(cr, header); err != nil {
+		return
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, nil, fmt.Errorf("%w: bad magic %q", ErrCorrupt, header[:len(snapshotMagic)])
+	}
+	if v := header[len(snapshotMagic)]; v != snapshotVersion {
+		return nil, nil, fmt.Errorf("hashmap: unsupported format version %d", v)
+	}
+	n, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return
+	}
+	// entries are checked against the checksum before being decoded, as a
+	// corrupted value may fail to decode or decode to garbage
+	type record struct {
+		k any
+		v []byte
+	}
+	records := make([]record, 0, min(n, 1<<16))
+	for i := uint64(0); i < n; i++ {
+		k, err := readKey(cr)
+		if err != nil {
+			return nil, nil, err
+		}
+		v, err := readBytes(cr)
+		if err != nil {
+			return nil, nil, err
+		}
+		records = append(records, record{k, v})
+	}
+	var sum [4]byte
+	if _, err = io.ReadFull(cr.r, sum[:]); err != nil {
+		return
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != cr.crc {
+		return nil, nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	// the checksum ends the data
+	switch _, err = cr.r.ReadByte(); err {
+	case nil:
+		return nil, nil, fmt.Errorf("%w: data after the checksum", ErrCorrupt)
+	case io.EOF:
+		err = nil
+	default:
+		return
+	}
+	ks, vs = make([]K, len(records)), make([]V, len(records))
+	for i, rec := range records {
+		if ks[i], err = keyOf[K](rec.k); err != nil {
+			return nil, nil, err
+		}
+		if vs[i], err = m.codec.Decode(rec.v); err != nil {
+			return nil, nil, err
+		}
+	}
+	return
+}
+
+// SaveFile saves the map to the file at path like SaveTo. The data is
+// written to a temporary file in the same directory, synced, and renamed
+// over path, so that path holds either the previous or the new contents
+// even if the process crashes.
+func (m *HashMap[K, V]) SaveFile(path string) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if err = m.SaveTo(f); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err = d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
+
+// LoadFile loads the file at path, written by SaveFile, like LoadFrom.
+func (m *HashMap[K, V]) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.LoadFrom(f)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, encoding the entries of
+// the map as SaveTo does, so that keys keep their types.
+func (m *HashMap[K, V]) MarshalBinary() ([]byte, error) {
+	if m.table.Load() == nil {
+		m = New[K, V]()
+	}
+	var b bytes.Buffer
+	err := m.SaveTo(&b)
+	return b.Bytes(), err
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, setting the entries
+// encoded by MarshalBinary in the map as LoadFrom does. A zero HashMap, such
+// as one allocated by encoding/gob, is set up with the defaults of New.
+func (m *HashMap[K, V]) UnmarshalBinary(b []byte) error {
+	if m.table.Load() == nil {
+		m.init(defaultCapacity, defaultLoadFactor, maxCapacity)
+	}
+	return m.LoadFrom(bytes.NewReader(b))
+}
+
+// GobEncode implements gob.GobEncoder like MarshalBinary, so that maps can
+// be fields of gob-encoded values.
+func (m *HashMap[K, V]) GobEncode() ([]byte, error) {
+	return m.MarshalBinary()
+}
+
+// GobDecode implements gob.GobDecoder like UnmarshalBinary.
+func (m *HashMap[K, V]) GobDecode(b []byte) error {
+	return m.UnmarshalBinary(b)
+}
@@ -0,0 +1,277 @@
+package hashmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashMap_SaveLoad(t *testing.T) {
+	m := New[string, []int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(string(rune('a'+i%26))+string(rune(i)), []int{i, i * 2})
+	}
+	m.LogicDel("a\x00")
+	var b bytes.Buffer
+	if err := m.SaveTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	l := New[string, []int]()
+	l.Set("x", []int{1})
+	if err := l.LoadFrom(bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, l.Size(), int64(1000))
+	m.Range(func(k string, v []int) bool {
+		got, ok := l.Get(k)
+		if !ok || len(got) != 2 || got[0] != v[0] || got[1] != v[1] {
+			t.Fatal("load err", k, got, ok)
+		}
+		return true
+	})
+	_, ok := l.Get("x")
+	assertEqual(t, ok, true)
+}
+
+func TestHashMap_SaveLoadAny(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	keys := []any{nil, "s", true, at, int(-1), int8(-2), int16(-3), int32(-4), int64(-5),
+		uint(1), uint8(2), uint16(3), uint32(4), uint64(5), uintptr(6), float32(1.5), float64(2.5)}
+	m := NewAny()
+	for i, k := range keys {
+		m.Set(k, i)
+	}
+	var b bytes.Buffer
+	if err := m.SaveTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	l := NewAny()
+	if err := l.LoadFrom(&b); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, l.Size(), int64(len(keys)))
+	for i, k := range keys {
+		v, ok := l.Get(k)
+		if !ok || v != i {
+			t.Fatalf("key %T(%v): %v %v", k, k, v, ok)
+		}
+	}
+	// 1 and int64(1) are distinct keys
+	_, ok := l.Get(int64(-1))
+	assertEqual(t, ok, false)
+
+	m.Set(struct{}{}, 0)
+	if err := m.SaveTo(&b); err == nil {
+		t.Fatal("saved an unsupported key type")
+	}
+}
+
+func TestHashMap_SaveLoadDefinedKey(t *testing.T) {
+	type id string
+	type port uint16
+	m := New[id, port]()
+	m.Set("web", 80)
+	m.Set("tls", 443)
+	var b bytes.Buffer
+	if err := m.SaveTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	saved := b.Bytes()
+	l := New[id, port]()
+	if err := l.LoadFrom(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, l.Size(), int64(2))
+	v, _ := l.Get("tls")
+	assertEqual(t, v, port(443))
+
+	// saved as the underlying type
+	a := New[any, port]()
+	if err := a.LoadFrom(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	_, ok := a.Get("web")
+	assertEqual(t, ok, true)
+	p := New[port, int]()
+	if err := p.LoadFrom(bytes.NewReader(saved)); err == nil {
+		t.Fatal("loaded a string key into a uint16 map")
+	}
+
+	type stamp time.Time
+	s := New[stamp, int]()
+	s.Set(stamp(time.Now()), 1)
+	if err := s.SaveTo(&b); err == nil {
+		t.Fatal("saved a key of a type defined over time.Time")
+	}
+}
+
+func TestHashMap_LoadKeyMismatch(t *testing.T) {
+	m := NewAny()
+	m.Set("a", 1)
+	m.Set(2, 2)
+	var b bytes.Buffer
+	if err := m.SaveTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	l := New[string, any]()
+	if err := l.LoadFrom(&b); err == nil {
+		t.Fatal("loaded an int key into a string map")
+	}
+	assertEqual(t, l.Size(), int64(0))
+}
+
+func TestHashMap_LoadCorrupt(t *testing.T) {
+	m := New[int, string]()
+	for i := 0; i < 100; i++ {
+		m.Set(i, "v")
+	}
+	var b bytes.Buffer
+	if err := m.SaveTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+	for _, pos := range []int{0, 6, len(data) / 2, len(data) - 5, len(data) - 1} {
+		bad := bytes.Clone(data)
+		bad[pos] ^= 0x40
+		l := New[int, string]()
+		if err := l.LoadFrom(bytes.NewReader(bad)); !errors.Is(err, ErrCorrupt) {
+			t.Fatal("corruption at", pos, "not detected:", err)
+		}
+		assertEqual(t, l.Size(), int64(0))
+	}
+	for _, n := range []int{0, 3, len(data) / 2, len(data) - 1} {
+		l := New[int, string]()
+		if err := l.LoadFrom(bytes.NewReader(data[:n])); !errors.Is(err, ErrCorrupt) {
+			t.Fatal("truncation at", n, "not detected:", err)
+		}
+		assertEqual(t, l.Size(), int64(0))
+	}
+
+	l := New[int, string]()
+	if err := l.LoadFrom(bytes.NewReader(append(bytes.Clone(data), 0))); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("trailing data not detected:", err)
+	}
+	assertEqual(t, l.Size(), int64(0))
+
+	bad := bytes.Clone(data)
+	bad[len(snapshotMagic)] = snapshotVersion + 1
+	if err := New[int, string]().LoadFrom(bytes.NewReader(bad)); err == nil {
+		t.Fatal("loaded an unknown version")
+	}
+}
+
+func TestHashMap_SaveLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map")
+	m := New[int, int]()
+	m.Set(1, 1)
+	if err := m.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	m.Set(2, 2)
+	if err := m.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	assertEqual(t, len(entries), 1)
+
+	l := New[int, int]()
+	if err := l.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, l.Size(), int64(2))
+	v, _ := l.Get(2)
+	assertEqual(t, v, 2)
+}
+
+func TestHashMap_Codec(t *testing.T) {
+	type point struct{ X, Y int }
+	m, err := NewWithOptions[string, point](WithCodec[point](JSONCodec[point]{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Set("p", point{1, 2})
+	var b bytes.Buffer
+	if err = m.SaveTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b.Bytes(), []byte(`{"X":1,"Y":2}`)) {
+		t.Fatal("value not encoded by the codec")
+	}
+	l, _ := NewWithOptions[string, point](WithCodec[point](JSONCodec[point]{}))
+	if err = l.LoadFrom(&b); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := l.Get("p")
+	assertEqual(t, v, point{1, 2})
+
+	if _, err = NewWithOptions[string, int](WithCodec[point](JSONCodec[point]{})); err == nil {
+		t.Fatal("accepted a codec of another value type")
+	}
+}
+
+func TestHashMap_MarshalBinary(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := NewAny()
+	m.Set(1, "int")
+	m.Set("1", "string")
+	m.Set(at, "time")
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l AnyMap
+	if err = l.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, l.Size(), int64(3))
+	for k, want := range map[any]string{1: "int", "1": "string", at: "time"} {
+		if v, ok := l.Get(k); !ok || v != want {
+			t.Fatal("unmarshal err", k, v, ok)
+		}
+	}
+	l.Set(2, "resizable")
+
+	var zero HashMap[string, int]
+	b, err = zero.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := New[string, int]()
+	assertEqual(t, n.UnmarshalBinary(b), nil)
+	assertEqual(t, n.Size(), int64(0))
+}
+
+func TestHashMap_Gob(t *testing.T) {
+	type message struct {
+		ID    int
+		Typed *HashMap[string, []string]
+		Any   *AnyMap
+	}
+	in := message{ID: 1, Typed: New[string, []string](), Any: NewAny()}
+	in.Typed.Set("a", []string{"x", "y"})
+	in.Any.Set(int64(1), 1.5)
+	in.Any.Set(uint8(2), "two")
+
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(in); err != nil {
+		t.Fatal(err)
+	}
+	var out message
+	if err := gob.NewDecoder(&b).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, out.ID, 1)
+	v, _ := out.Typed.Get("a")
+	assertEqual(t, len(v) == 2 && v[1] == "y", true)
+	f, _ := out.Any.Get(int64(1))
+	assertEqual(t, f, 1.5)
+	s, _ := out.Any.Get(uint8(2))
+	assertEqual(t, s, "two")
+	_, ok := out.Any.Get(1)
+	assertEqual(t, ok, false)
+}
@@ -0,0 +1,141 @@
+package hashmap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Snapshot is a read-only view of the entries of a map at one instant. It
+// is not affected by later writes to the map.
+type Snapshot[K comparable, V any] struct {
+	keys    []K
+	values  []V
+	expires []int64 // expiration times as returned by now, 0 if none
+
+	once  sync.Once
+	index map[K]int
+}
+
+// snapshot is the state of a snapshot being taken. Writers copy a node into
+// it before modifying the node for the first time, so that the node is
+// recorded as it was when the snapshot started.
+type snapshot[K comparable, V any] struct {
+	sync.Mutex
+
+	gen     uint64
+	at      int64 // entries expiring at or before at are left out
+	keys    []K
+	values  []V
+	expires []int64
+}
+
+// Snapshot returns the entries present in the map at the instant it is
+// called. Writers are only held up briefly at the start; afterwards each
+// node is copied either by Snapshot or by the first writer to modify it,
+// and resizing is deferred until the copy is complete.
+func (m *HashMap[K, V]) Snapshot() *Snapshot[K, V] {
+	return m.snapshot(nil)
+}
+
+// snapshot takes a snapshot, calling started, if not nil, at the instant it
+// is taken, while no writer runs.
+func (m *HashMap[K, V]) snapshot(started func()) *Snapshot[K, V] {
+	m.snapMu.Lock()
+	defer m.snapMu.Unlock()
+
+	m.Lock()
+	m.snapGen++
+	s := &snapshot[K, V]{gen: m.snapGen, at: now()}
+	m.snap.Store(s)
+	t, old := m.table.Load(), m.old.Load()
+	if started != nil {
+		started()
+	}
+	m.Unlock()
+
+	if old != nil {
+		for i := range old.nodes {
+			m.copyNode(old, &old.nodes[i])
+		}
+	}
+	for i := range t.nodes {
+		m.copyNode(t, &t.nodes[i])
+	}
+	m.snap.Store(nil)
+	return &Snapshot[K, V]{keys: s.keys, values: s.values, expires: s.expires}
+}
+
+func (m *HashMap[K, V]) copyNode(t *Table[K, V], n *Node[K, V]) {
+	n.Lock()
+	defer n.Unlock()
+	if atomic.LoadInt32(&n.moved) == 0 {
+		m.preserve(t, n)
+	}
+}
+
+// preserve copies the live entries of n into the snapshot being taken, if
+// any and if n has not been copied yet. n must be locked, and must not be
+// modified before preserve is called.
+func (m *HashMap[K, V]) preserve(t *Table[K, V], n *Node[K, V]) {
+	s := m.snap.Load()
+	if s == nil || n.snap == s.gen {
+		return
+	}
+	n.snap = s.gen
+	s.Lock()
+	defer s.Unlock()
+	for e := n.head.Load(); e != nil; e = e.next[t.ab].Load() {
+		if x := atomic.LoadInt64(&e.expire); atomic.LoadInt32(&e.flag) == 0 && (x == 0 || x > s.at) {
+			s.keys = append(s.keys, e.k)
+			s.values = append(s.values, e.Value())
+			s.expires = append(s.expires, x)
+		}
+	}
+}
+
+// snapshotting reports whether a snapshot is being taken, during which
+// writers must lock nodes to modify them and tables are not resized.
+func (m *HashMap[K, V]) snapshotting() bool {
+	return m.snap.Load() != nil
+}
+
+// Size returns the number of entries in the snapshot.
+func (s *Snapshot[K, V]) Size() int64 {
+	return int64(len(s.keys))
+}
+
+// Keys returns the keys of the snapshot, in no particular order. The slice
+// must not be modified.
+func (s *Snapshot[K, V]) Keys() []K {
+	return s.keys
+}
+
+// Values returns the values of the snapshot, in the order of Keys. The slice
+// must not be modified.
+func (s *Snapshot[K, V]) Values() []V {
+	return s.values
+}
+
+// Get returns the value of k in the snapshot.
+func (s *Snapshot[K, V]) Get(k K) (v V, ok bool) {
+	s.once.Do(func() {
+		s.index = make(map[K]int, len(s.keys))
+		for i, k := range s.keys {
+			s.index[k] = i
+		}
+	})
+	if i, ok := s.index[k]; ok {
+		return s.values[i], true
+	}
+	return v, false
+}
+
+// Range calls fn for each entry of the snapshot, stopping if fn returns
+// false.
+func (s *Snapshot[K, V]) Range(fn func(k K, v V) bool) {
+	for i, k := range s.keys {
+		if !fn(k, s.values[i]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package hashmap
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestHashMap_Snapshot(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 100; i++ {
+		m.Set(i, i*2)
+	}
+	m.LogicDel(10)
+	s := m.Snapshot()
+	m.Set(0, -1)
+	m.Set(100, 200)
+	m.Del(1)
+	m.LogicDel(2)
+
+	assertEqual(t, s.Size(), int64(99))
+	assertEqual(t, len(s.Keys()), 99)
+	assertEqual(t, len(s.Values()), 99)
+	for i := 0; i < 101; i++ {
+		v, ok := s.Get(i)
+		if ok != (i != 10 && i != 100) || ok && v != i*2 {
+			t.Fatal("snapshot err", i, v, ok)
+		}
+	}
+	n := 0
+	s.Range(func(k, v int) bool {
+		assertEqual(t, v, k*2)
+		n++
+		return n < 50
+	})
+	assertEqual(t, n, 50)
+
+	// the map is unaffected by the snapshot
+	v, _ := m.Get(0)
+	assertEqual(t, v, -1)
+	assertEqual(t, m.Size(), int64(98))
+	assertEqual(t, m.Snapshot().Size(), int64(98))
+}
+
+func TestHashMap_SnapshotConcurrent(t *testing.T) {
+	m, err := NewWithOptions[int, int](WithCapacity(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := 200000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// at any instant the keys present are a contiguous range
+		for i := 0; i < batch; i++ {
+			m.Set(i, i)
+		}
+		for i := 0; i < batch; i++ {
+			m.Del(i)
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		keys := m.Snapshot().Keys()
+		if len(keys) == 0 {
+			continue
+		}
+		sort.Ints(keys)
+		if keys[len(keys)-1]-keys[0] != len(keys)-1 {
+			t.Fatalf("snapshot of %d keys spans %d to %d", len(keys), keys[0], keys[len(keys)-1])
+		}
+	}
+	assertEqual(t, m.Size(), int64(0))
+}
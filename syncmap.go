@@ -0,0 +1,122 @@
+package hashmap
+
+// The methods below mirror sync.Map, so that an AnyMap can replace a
+// sync.Map without changing its call sites. Each one runs with the node
+// holding the key locked, so it is atomic with respect to the other methods
+// that lock it. Set and SetWithTTL replace the value of an existing key
+// without the lock; CompareAndSwap and CompareAndDelete still only succeed
+// if the value they replace or delete is old, and LoadAndDelete returns the
+// value it deleted.
+
+// Load returns the value stored for k, like Get.
+func (m *HashMap[K, V]) Load(k K) (v V, ok bool) {
+	return m.Get(k)
+}
+
+// Store sets the value for k and clears its expiration.
+func (m *HashMap[K, V]) Store(k K, v V) {
+	m.Swap(k, v)
+}
+
+// LoadOrStore returns the existing value for k if present. Otherwise it
+// stores and returns v. loaded is true if the value was loaded.
+func (m *HashMap[K, V]) LoadOrStore(k K, v V) (actual V, loaded bool) {
+	h := m.mustHash(k)
+	m.record(h)
+	actual, loaded = v, true
+	added := m.update(k, h, func(t *Table[K, V], n *Node[K, V], e *Entry[K, V]) *Entry[K, V] {
+		if e != nil {
+			m.touch(e)
+			actual = e.Value()
+			return nil
+		}
+		loaded = false
+		return m.newEntry(k, v, h, 0)
+	})
+	if added != nil && m.maxEntries > 0 {
+		m.evict(added)
+	}
+	return
+}
+
+// LoadAndDelete deletes k, returning its previous value if any. loaded is
+// true if k was present.
+func (m *HashMap[K, V]) LoadAndDelete(k K) (v V, loaded bool) {
+	m.update(k, m.mustHash(k), func(t *Table[K, V], n *Node[K, V], e *Entry[K, V]) *Entry[K, V] {
+		for e != nil {
+			p := e.p.Load()
+			if m.removeValue(t, n, e, p) {
+				v, loaded = *p, true
+				return nil
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Delete deletes k, like Del.
+func (m *HashMap[K, V]) Delete(k K) {
+	m.Del(k)
+}
+
+// Swap stores v for k, clearing its expiration, and returns the previous
+// value if any. loaded is true if k was present.
+func (m *HashMap[K, V]) Swap(k K, v V) (previous V, loaded bool) {
+	h := m.mustHash(k)
+	m.record(h)
+	added := m.update(k, h, func(t *Table[K, V], n *Node[K, V], e *Entry[K, V]) *Entry[K, V] {
+		if e != nil {
+			previous, loaded = m.replace(e, v, 0, nil), true
+			return nil
+		}
+		return m.newEntry(k, v, h, 0)
+	})
+	if added != nil && m.maxEntries > 0 {
+		m.evict(added)
+	}
+	return
+}
+
+// CompareAndSwap stores new for k if its value is equal to old, keeping its
+// expiration. Like sync.Map, it panics if old is not comparable.
+func (m *HashMap[K, V]) CompareAndSwap(k K, old, new V) (swapped bool) {
+	m.update(k, m.mustHash(k), func(t *Table[K, V], n *Node[K, V], e *Entry[K, V]) *Entry[K, V] {
+		var enc []byte
+		if e != nil {
+			enc = m.encode(new)
+		}
+		for e != nil {
+			p := e.p.Load()
+			if any(*p) != any(old) {
+				break
+			}
+			if e.p.CompareAndSwap(p, &new) {
+				m.touch(e)
+				m.notify(OpSet, e, *p, new, enc)
+				swapped = true
+				break
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// CompareAndDelete deletes k if its value is equal to old. Like sync.Map, it
+// panics if old is not comparable.
+func (m *HashMap[K, V]) CompareAndDelete(k K, old V) (deleted bool) {
+	m.update(k, m.mustHash(k), func(t *Table[K, V], n *Node[K, V], e *Entry[K, V]) *Entry[K, V] {
+		for e != nil {
+			p := e.p.Load()
+			if any(*p) != any(old) {
+				return nil
+			}
+			if deleted = m.removeValue(t, n, e, p); deleted {
+				return nil
+			}
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,193 @@
+package hashmap
+
+import (
+	"sort"
+	"sync"
+	"testing"
+)
+
+// syncMap is the sync.Map API, implemented by both *sync.Map and *AnyMap.
+type syncMap interface {
+	Load(k any) (any, bool)
+	Store(k, v any)
+	LoadOrStore(k, v any) (any, bool)
+	LoadAndDelete(k any) (any, bool)
+	Delete(k any)
+	Swap(k, v any) (any, bool)
+	CompareAndSwap(k, old, new any) bool
+	CompareAndDelete(k, old any) bool
+	Range(fn func(k, v any) bool)
+}
+
+var (
+	_ syncMap = (*sync.Map)(nil)
+	_ syncMap = (*AnyMap)(nil)
+)
+
+func TestSyncMap(t *testing.T) {
+	impls := []struct {
+		name string
+		new  func() syncMap
+	}{
+		{"sync.Map", func() syncMap { return &sync.Map{} }},
+		{"HashMap", func() syncMap { return NewAny() }},
+	}
+	for _, impl := range impls {
+		t.Run(impl.name, func(t *testing.T) {
+			testSyncMap(t, impl.new)
+		})
+	}
+}
+
+func testSyncMap(t *testing.T, newMap func() syncMap) {
+	t.Run("LoadStore", func(t *testing.T) {
+		m := newMap()
+		_, ok := m.Load("a")
+		assertEqual(t, ok, false)
+		m.Store("a", 1)
+		m.Store(2, "b")
+		v, ok := m.Load("a")
+		assertEqual(t, ok, true)
+		assertEqual(t, v, 1)
+		v, _ = m.Load(2)
+		assertEqual(t, v, "b")
+		m.Store("a", 3)
+		v, _ = m.Load("a")
+		assertEqual(t, v, 3)
+		m.Delete("a")
+		m.Delete("missing")
+		_, ok = m.Load("a")
+		assertEqual(t, ok, false)
+	})
+	t.Run("LoadOrStore", func(t *testing.T) {
+		m := newMap()
+		v, loaded := m.LoadOrStore("a", 1)
+		assertEqual(t, loaded, false)
+		assertEqual(t, v, 1)
+		v, loaded = m.LoadOrStore("a", 2)
+		assertEqual(t, loaded, true)
+		assertEqual(t, v, 1)
+	})
+	t.Run("LoadAndDelete", func(t *testing.T) {
+		m := newMap()
+		_, loaded := m.LoadAndDelete("a")
+		assertEqual(t, loaded, false)
+		m.Store("a", 1)
+		v, loaded := m.LoadAndDelete("a")
+		assertEqual(t, loaded, true)
+		assertEqual(t, v, 1)
+		_, ok := m.Load("a")
+		assertEqual(t, ok, false)
+	})
+	t.Run("Swap", func(t *testing.T) {
+		m := newMap()
+		v, loaded := m.Swap("a", 1)
+		assertEqual(t, loaded, false)
+		assertEqual(t, v, nil)
+		v, loaded = m.Swap("a", 2)
+		assertEqual(t, loaded, true)
+		assertEqual(t, v, 1)
+		v, _ = m.Load("a")
+		assertEqual(t, v, 2)
+	})
+	t.Run("CompareAndSwap", func(t *testing.T) {
+		m := newMap()
+		assertEqual(t, m.CompareAndSwap("a", nil, 1), false)
+		m.Store("a", 1)
+		assertEqual(t, m.CompareAndSwap("a", 2, 3), false)
+		assertEqual(t, m.CompareAndSwap("a", 1, 3), true)
+		v, _ := m.Load("a")
+		assertEqual(t, v, 3)
+	})
+	t.Run("CompareAndDelete", func(t *testing.T) {
+		m := newMap()
+		assertEqual(t, m.CompareAndDelete("a", nil), false)
+		m.Store("a", 1)
+		assertEqual(t, m.CompareAndDelete("a", 2), false)
+		assertEqual(t, m.CompareAndDelete("a", 1), true)
+		_, ok := m.Load("a")
+		assertEqual(t, ok, false)
+	})
+	t.Run("Range", func(t *testing.T) {
+		m := newMap()
+		for i := 0; i < 100; i++ {
+			m.Store(i, i*2)
+		}
+		m.Delete(50)
+		var keys []int
+		m.Range(func(k, v any) bool {
+			if v != k.(int)*2 {
+				t.Error("value err", k, v)
+			}
+			keys = append(keys, k.(int))
+			return true
+		})
+		sort.Ints(keys)
+		assertEqual(t, len(keys), 99)
+		for i, k := range keys {
+			if want := i + i/50; k != want {
+				t.Fatal("key err", k, want)
+			}
+		}
+		n := 0
+		m.Range(func(k, v any) bool {
+			n++
+			return n < 10
+		})
+		assertEqual(t, n, 10)
+	})
+	t.Run("Concurrent", func(t *testing.T) {
+		m := newMap()
+		workers, increments := 8, 1000
+		wins := make([]int, workers)
+		wg := sync.WaitGroup{}
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				if _, loaded := m.LoadOrStore("winner", w); !loaded {
+					wins[w]++
+				}
+				for i := 0; i < increments; i++ {
+					for {
+						v, loaded := m.LoadOrStore("counter", 1)
+						if !loaded || m.CompareAndSwap("counter", v, v.(int)+1) {
+							break
+						}
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		total := 0
+		for _, n := range wins {
+			total += n
+		}
+		assertEqual(t, total, 1)
+		v, _ := m.Load("counter")
+		assertEqual(t, v, workers*increments)
+	})
+}
+
+func TestSyncMap_CompareAndDeleteRace(t *testing.T) {
+	m := New[string, int]()
+	// a Set racing the delete either makes it fail or is applied after it
+	for i := 0; i < 10000; i++ {
+		m.Set("k", 10)
+		wg := sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.CompareAndDelete("k", 10)
+		}()
+		go func() {
+			defer wg.Done()
+			m.Set("k", 11)
+		}()
+		wg.Wait()
+		if v, ok := m.Get("k"); !ok || v != 11 {
+			t.Fatal("lost write", i, v, ok)
+		}
+		assertEqual(t, m.Size(), int64(1))
+	}
+}
@@ -0,0 +1,32 @@
+package hashmap
+
+import "sync/atomic"
+
+// WithTombstonePurge makes the janitor also unlink the tombstones left by
+// LogicDel, every janitor interval. Without it they are only unlinked by
+// Purge, or when their key is set again.
+func WithTombstonePurge() Option {
+	return func(c *config) {
+		c.purgeTombstones = true
+	}
+}
+
+// Tombstones returns the number of entries removed by LogicDel that are
+// still linked into the map. They are not counted by Size.
+func (m *HashMap[K, V]) Tombstones() int64 {
+	return atomic.LoadInt64(&m.tombstones)
+}
+
+// Purge unlinks the tombstones left by LogicDel and returns how many it
+// unlinked.
+func (m *HashMap[K, V]) Purge() (purged int64) {
+	for {
+		removed, complete := m.sweep(func(e *Entry[K, V]) bool {
+			return atomic.LoadInt32(&e.flag) != 0
+		})
+		purged += removed
+		if complete {
+			return
+		}
+	}
+}
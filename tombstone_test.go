@@ -0,0 +1,119 @@
+package hashmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHashMap_LogicDelResurrect(t *testing.T) {
+	m := New[string, int]()
+	m.Set("a", 1)
+	m.Set("b", 2)
+	assertEqual(t, m.LogicDel("a"), true)
+	assertEqual(t, m.LogicDel("b"), true)
+	assertEqual(t, m.Tombstones(), int64(2))
+	assertEqual(t, m.Size(), int64(0))
+
+	assertEqual(t, m.SetNX("a", 3), true)
+	v, ok := m.Get("a")
+	assertEqual(t, ok, true)
+	assertEqual(t, v, 3)
+	assertEqual(t, m.Set("b", 4), 0)
+	v, ok = m.Get("b")
+	assertEqual(t, ok, true)
+	assertEqual(t, v, 4)
+	assertEqual(t, m.Tombstones(), int64(0))
+	assertEqual(t, m.Size(), int64(2))
+
+	// the resurrected keys can be deleted again
+	assertEqual(t, m.LogicDel("a"), true)
+	assertEqual(t, m.Del("b"), true)
+	assertEqual(t, m.Tombstones(), int64(1))
+	assertEqual(t, m.Size(), int64(0))
+}
+
+func TestHashMap_Purge(t *testing.T) {
+	m := New[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	for i := 0; i < 1000; i += 2 {
+		m.LogicDel(i)
+	}
+	assertEqual(t, m.Tombstones(), int64(500))
+	linked := 0
+	m.Foreach(func(e *Entry[int, int]) {
+		linked++
+	})
+	assertEqual(t, linked, 1000)
+
+	assertEqual(t, m.Purge(), int64(500))
+	assertEqual(t, m.Tombstones(), int64(0))
+	assertEqual(t, m.Size(), int64(500))
+	linked = 0
+	m.Foreach(func(e *Entry[int, int]) {
+		if e.Flag() != 0 {
+			t.Fatal("tombstone left", e.Key())
+		}
+		linked++
+	})
+	assertEqual(t, linked, 500)
+	assertEqual(t, m.Purge(), int64(0))
+}
+
+func TestWithTombstonePurge(t *testing.T) {
+	m, err := NewWithOptions[int, int](WithTombstonePurge(), WithJanitorInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+		m.LogicDel(i)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Tombstones() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not purge tombstones, left", m.Tombstones())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertEqual(t, m.Size(), int64(0))
+}
+
+func TestHashMap_TombstoneAccounting(t *testing.T) {
+	m := New[int, int]()
+	keys := 100
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20000; i++ {
+				k := (i * (w + 1)) % keys
+				switch i % 4 {
+				case 0:
+					m.Set(k, i)
+				case 1:
+					m.LogicDel(k)
+				case 2:
+					m.Del(k)
+				default:
+					m.SetNX(k, i)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	live, tombstones := int64(0), int64(0)
+	m.Foreach(func(e *Entry[int, int]) {
+		if e.Flag() == 0 {
+			live++
+		} else {
+			tombstones++
+		}
+	})
+	assertEqual(t, m.Size(), live)
+	assertEqual(t, m.Tombstones(), tombstones)
+}
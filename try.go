@@ -0,0 +1,97 @@
+package hashmap
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+// ErrUnsupportedKey is returned by the Try methods for keys that cannot be
+// hashed or compared, such as slices, maps and funcs stored in an interface
+// key, or logged by a map with a log. The other methods panic with it
+// instead.
+var ErrUnsupportedKey = errors.New("hashmap: unsupported key type")
+
+// mayPanicOnCompare reports whether comparing two values of type t can panic
+// at run time, which is the case when t is or contains an interface.
+func mayPanicOnCompare(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Array:
+		return mayPanicOnCompare(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if mayPanicOnCompare(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkKey(k any) (err error) {
+	switch k.(type) {
+	case nil, string, bool, time.Time, int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, float32, float64, uintptr:
+		return nil
+	case []byte:
+		return ErrUnsupportedKey
+	}
+	defer func() {
+		if recover() != nil {
+			err = ErrUnsupportedKey
+		}
+	}()
+	_ = k == k
+	return nil
+}
+
+// TrySet is like Set but returns ErrUnsupportedKey instead of panicking.
+func (m *HashMap[K, V]) TrySet(k K, v V) (V, error) {
+	h, err := m.hash(k)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return m.set(k, h, v, 0), nil
+}
+
+// TrySetNX is like SetNX but returns ErrUnsupportedKey instead of panicking.
+func (m *HashMap[K, V]) TrySetNX(k K, v V) (bool, error) {
+	h, err := m.hash(k)
+	if err != nil {
+		return false, err
+	}
+	return m.setNX(k, h, v), nil
+}
+
+// TryGet is like Get but returns ErrUnsupportedKey instead of panicking.
+func (m *HashMap[K, V]) TryGet(k K) (V, bool, error) {
+	h, err := m.hash(k)
+	if err != nil {
+		var zero V
+		return zero, false, err
+	}
+	v, ok := m.get(k, h)
+	return v, ok, nil
+}
+
+// TryDel is like Del but returns ErrUnsupportedKey instead of panicking.
+func (m *HashMap[K, V]) TryDel(k K) (bool, error) {
+	h, err := m.hash(k)
+	if err != nil {
+		return false, err
+	}
+	return m.del(k, h), nil
+}
+
+// TryLogicDel is like LogicDel but returns ErrUnsupportedKey instead of
+// panicking.
+func (m *HashMap[K, V]) TryLogicDel(k K) (bool, error) {
+	h, err := m.hash(k)
+	if err != nil {
+		return false, err
+	}
+	return m.logicDel(k, h), nil
+}
@@ -0,0 +1,60 @@
+package hashmap
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHashMap_TryUnsupportedKey(t *testing.T) {
+	m := NewAny()
+	m.Set("a", 1)
+	bad := []any{[]byte("a"), []int{1}, map[string]int{}, func() {}, tenantKey{}, struct{ k any }{[]int{1}}}
+	for _, k := range bad[:4] {
+		if _, err := m.TrySet(k, 1); !errors.Is(err, ErrUnsupportedKey) {
+			t.Fatalf("TrySet(%T) err = %v", k, err)
+		}
+		if _, err := m.TrySetNX(k, 1); !errors.Is(err, ErrUnsupportedKey) {
+			t.Fatalf("TrySetNX(%T) err = %v", k, err)
+		}
+		if _, _, err := m.TryGet(k); !errors.Is(err, ErrUnsupportedKey) {
+			t.Fatalf("TryGet(%T) err = %v", k, err)
+		}
+		if _, err := m.TryDel(k); !errors.Is(err, ErrUnsupportedKey) {
+			t.Fatalf("TryDel(%T) err = %v", k, err)
+		}
+		if _, err := m.TryLogicDel(k); !errors.Is(err, ErrUnsupportedKey) {
+			t.Fatalf("TryLogicDel(%T) err = %v", k, err)
+		}
+	}
+	if _, err := m.TrySet(bad[4], 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.TrySet(bad[5], 1); !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatalf("TrySet(%T) err = %v", bad[5], err)
+	}
+	assertEqual(t, m.Size(), int64(2))
+
+	v, ok, err := m.TryGet("a")
+	if err != nil || !ok || v != 1 {
+		t.Fatal("TryGet err", v, ok, err)
+	}
+}
+
+func TestHashMap_SetUnsupportedKeyPanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r != ErrUnsupportedKey {
+			t.Fatalf("recovered %v", r)
+		}
+	}()
+	NewAny().Set([]int{1}, 1)
+}
+
+func TestHashMap_TryStructKey(t *testing.T) {
+	m := New[struct{ k any }, int]()
+	if _, err := m.TrySet(struct{ k any }{1}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.TrySet(struct{ k any }{[]int{1}}, 1); !errors.Is(err, ErrUnsupportedKey) {
+		t.Fatal("expected ErrUnsupportedKey, got", err)
+	}
+}
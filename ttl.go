@@ -0,0 +1,186 @@
+package hashmap
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultJanitorInterval = time.Second
+
+// start is the reference for expiration times, which are kept on the
+// monotonic clock so that changes to the wall clock do not affect TTLs.
+var start = time.Now()
+
+func now() int64 {
+	return int64(time.Since(start)) + 1
+}
+
+// deadline returns the expiration time for ttl, 0 for no expiration.
+func deadline(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return now() + int64(ttl)
+}
+
+// expired reports whether e has a TTL that has run out.
+func (e *Entry[K, V]) expired() bool {
+	x := atomic.LoadInt64(&e.expire)
+	return x != 0 && x <= now()
+}
+
+// janitor periodically removes expired entries, and tombstones with
+// WithTombstonePurge. It is started by the first call that sets a TTL or
+// leaves a tombstone to purge, so other maps have no background goroutine.
+type janitor struct {
+	sync.Mutex
+
+	interval time.Duration
+	started  atomic.Bool
+	stop     chan struct{}
+	done     chan struct{}
+	closed   bool
+}
+
+// WithJanitorInterval sets how often expired entries are removed from the
+// map. It defaults to one second.
+func WithJanitorInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.janitorInterval = d
+	}
+}
+
+// SetWithTTL is like Set, but k expires after ttl. Once expired, k is absent
+// to Get and the other lookups, and a background janitor reclaims it. A ttl
+// of zero or less sets k without expiration.
+//
+// Expired entries count towards Size until they are reclaimed. Call Close
+// to stop the janitor when the map is no longer used.
+func (m *HashMap[K, V]) SetWithTTL(k K, v V, ttl time.Duration) V {
+	expire := deadline(ttl)
+	if expire != 0 {
+		m.startJanitor()
+	}
+	return m.set(k, m.mustHash(k), v, expire)
+}
+
+// Expire sets k to expire after ttl, or removes its expiration if ttl is
+// zero or less. It returns false if k is absent.
+func (m *HashMap[K, V]) Expire(k K, ttl time.Duration) bool {
+	h := m.mustHash(k)
+	expire := deadline(ttl)
+	if expire != 0 {
+		m.startJanitor()
+	}
+	m.RLock()
+	defer m.RUnlock()
+	t, n := m.lockNode(h)
+	defer n.Unlock()
+	if e := m.getNodeEntry(t, n, k); e != nil {
+		v := e.Value()
+		enc := m.encode(v)
+		atomic.StoreInt64(&e.expire, expire)
+		m.notify(OpSet, e, v, v, enc)
+		return true
+	}
+	return false
+}
+
+// TTL returns the time left before k expires, or zero if k does not expire.
+// ok is false if k is absent, including if it expired since it was looked
+// up.
+func (m *HashMap[K, V]) TTL(k K) (ttl time.Duration, ok bool) {
+	e := m.lookup(k, m.mustHash(k))
+	if e == nil {
+		return 0, false
+	}
+	if x := atomic.LoadInt64(&e.expire); x != 0 {
+		if ttl = time.Duration(x - now()); ttl <= 0 {
+			return 0, false
+		}
+	}
+	return ttl, true
+}
+
+// Close stops the janitor and waits for it to exit. Expired entries are
+// still hidden from lookups afterwards, but are only reclaimed when
+// overwritten. If the map has a log, Close flushes, syncs and closes it, and
+// later writes are not logged.
+func (m *HashMap[K, V]) Close() error {
+	m.stopJanitor()
+	if m.oplog != nil {
+		return m.oplog.close()
+	}
+	return nil
+}
+
+func (m *HashMap[K, V]) stopJanitor() {
+	j := &m.janitor
+	j.Lock()
+	if j.closed {
+		j.Unlock()
+		return
+	}
+	j.closed = true
+	if j.stop != nil {
+		close(j.stop)
+	}
+	j.Unlock()
+	if j.done != nil {
+		<-j.done
+	}
+}
+
+func (m *HashMap[K, V]) startJanitor() {
+	j := &m.janitor
+	if j.started.Load() {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	if j.started.Load() || j.closed {
+		return
+	}
+	j.stop, j.done = make(chan struct{}), make(chan struct{})
+	j.started.Store(true)
+	go m.runJanitor(j.stop, j.done, j.interval)
+}
+
+func (m *HashMap[K, V]) runJanitor(stop, done chan struct{}, interval time.Duration) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.reclaim()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reclaim removes the expired entries, and the tombstones with
+// WithTombstonePurge. A failed log does not stop it: each removal panics
+// once done, so the sweep starts over until it completes, an expiration
+// missing from the log being replayed as expired anyway.
+func (m *HashMap[K, V]) reclaim() {
+	for !m.reclaimSweep() {
+	}
+}
+
+func (m *HashMap[K, V]) reclaimSweep() (done bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(error); !ok || !errors.Is(err, errLogFailed) {
+				panic(r)
+			}
+		}
+	}()
+	m.sweep(func(e *Entry[K, V]) bool {
+		return e.expired() || m.purge && atomic.LoadInt32(&e.flag) != 0
+	})
+	return true
+}
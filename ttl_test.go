@@ -0,0 +1,102 @@
+package hashmap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHashMap_SetWithTTL(t *testing.T) {
+	m := New[string, int]()
+	defer m.Close()
+	m.SetWithTTL("a", 1, 50*time.Millisecond)
+	m.Set("b", 2)
+
+	v, ok := m.Get("a")
+	assertEqual(t, ok, true)
+	assertEqual(t, v, 1)
+	ttl, ok := m.TTL("a")
+	if !ok || ttl <= 0 || ttl > 50*time.Millisecond {
+		t.Fatal("ttl err", ttl, ok)
+	}
+	ttl, ok = m.TTL("b")
+	assertEqual(t, ok, true)
+	assertEqual(t, ttl, time.Duration(0))
+
+	time.Sleep(60 * time.Millisecond)
+	_, ok = m.Get("a")
+	assertEqual(t, ok, false)
+	_, ok = m.TTL("a")
+	assertEqual(t, ok, false)
+	assertEqual(t, m.Del("a"), false)
+	assertEqual(t, m.Expire("a", time.Minute), false)
+
+	// an expired key can be set again before the janitor reclaims it
+	assertEqual(t, m.SetNX("a", 3), true)
+	v, _ = m.Get("a")
+	assertEqual(t, v, 3)
+	assertEqual(t, m.Size(), int64(2))
+
+	// Set clears the expiration of an existing key
+	m.SetWithTTL("b", 4, time.Minute)
+	m.Set("b", 5)
+	ttl, _ = m.TTL("b")
+	assertEqual(t, ttl, time.Duration(0))
+}
+
+func TestHashMap_Expire(t *testing.T) {
+	m := New[string, int]()
+	defer m.Close()
+	m.Set("a", 1)
+	assertEqual(t, m.Expire("a", 20*time.Millisecond), true)
+	assertEqual(t, m.Expire("missing", time.Second), false)
+	time.Sleep(30 * time.Millisecond)
+	_, ok := m.Get("a")
+	assertEqual(t, ok, false)
+
+	m.SetWithTTL("b", 2, 20*time.Millisecond)
+	assertEqual(t, m.Expire("b", 0), true)
+	time.Sleep(30 * time.Millisecond)
+	v, ok := m.Get("b")
+	assertEqual(t, ok, true)
+	assertEqual(t, v, 2)
+}
+
+func TestHashMap_Janitor(t *testing.T) {
+	m, err := NewWithOptions[int, int](WithJanitorInterval(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := 10000
+	for i := 0; i < batch; i++ {
+		if i%2 == 0 {
+			m.SetWithTTL(i, i, 100*time.Millisecond)
+		} else {
+			m.Set(i, i)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Size() != int64(batch/2) {
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not reclaim expired entries, size", m.Size())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 1; i < batch; i += 2 {
+		v, ok := m.Get(i)
+		if !ok || v != i {
+			t.Fatal("data err ", i)
+		}
+	}
+
+	assertEqual(t, m.Close(), nil)
+	assertEqual(t, m.Close(), nil)
+	m.SetWithTTL(-1, -1, time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	_, ok := m.Get(-1)
+	assertEqual(t, ok, false)
+	assertEqual(t, m.Size(), int64(batch/2+1))
+
+	if _, err = NewWithOptions[int, int](WithJanitorInterval(0)); err == nil {
+		t.Fatal("expected janitor interval error")
+	}
+}
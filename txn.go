@@ -0,0 +1,220 @@
+package hashmap
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+)
+
+// Tx gives a Txn callback access to the keys of the transaction. Its writes
+// are buffered and only applied to the map if the callback returns nil.
+type Tx[K comparable, V any] struct {
+	m     *HashMap[K, V]
+	slots map[K]*txSlot[K, V]
+}
+
+type txSlot[K comparable, V any] struct {
+	t  *Table[K, V]
+	n  *Node[K, V] // node holding the key, locked for the whole transaction
+	h  uint64
+	op int // txRead, txSet or txDel
+	v  V   // value set by the transaction
+	// v encoded by encode before the commit applies anything
+	enc []byte
+}
+
+const (
+	txRead = iota
+	txSet
+	txDel
+)
+
+// Txn runs fn with the nodes holding keys locked, so that fn can read and
+// write those keys through tx atomically with respect to the other writers.
+// The writes are applied if fn returns nil and discarded if it returns an
+// error, which Txn returns, or panics.
+//
+// Nodes are locked in a fixed order, so that concurrent transactions cannot
+// deadlock. While any transaction runs, Set and LogicDel lock nodes as the
+// other writers do, and Get and TTL as well, so that once a read observes a
+// write of a commit, later reads observe all of it. Reading several keys is
+// still not atomic in itself; read them in a Txn for a consistent view. fn
+// must not call methods of the map itself.
+func (m *HashMap[K, V]) Txn(keys []K, fn func(tx *Tx[K, V]) error) error {
+	hs := m.hashes(keys)
+	m.resize()
+	// registering under the write lock waits for the lock-free writers
+	// that started before the transaction
+	m.Lock()
+	atomic.AddInt32(&m.txns, 1)
+	m.Unlock()
+	defer atomic.AddInt32(&m.txns, -1)
+
+	added, err := m.txn(keys, hs, fn)
+	if m.maxEntries > 0 {
+		for _, e := range added {
+			m.evict(e)
+		}
+	}
+	return err
+}
+
+// mustLock reports whether writers must lock nodes to modify existing
+// entries, rather than swap their values lock-free: while a snapshot is
+// taken, a transaction runs or the map is watched, and if it has a log.
+func (m *HashMap[K, V]) mustLock() bool {
+	return m.oplog != nil || m.snapshotting() || atomic.LoadInt32(&m.txns) != 0 || m.watchers.Load() != nil
+}
+
+func (m *HashMap[K, V]) txn(keys []K, hs []uint64, fn func(tx *Tx[K, V]) error) (added []*Entry[K, V], err error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	// lock the node of every key in both tables, those of the old table
+	// first as migrateNode does, each table in index order; holding both
+	// keeps the entries from being migrated during the transaction
+	type lock struct {
+		t     *Table[K, V]
+		rank  int
+		index int
+	}
+	t, old := m.table.Load(), m.old.Load()
+	locks := make([]lock, 0, 2*len(hs))
+	for _, h := range hs {
+		if old != nil {
+			locks = append(locks, lock{old, 0, indexOf(h, old.len())})
+		}
+		locks = append(locks, lock{t, 1, indexOf(h, t.len())})
+	}
+	slices.SortFunc(locks, func(a, b lock) int {
+		return cmp.Or(cmp.Compare(a.rank, b.rank), cmp.Compare(a.index, b.index))
+	})
+	locks = slices.Compact(locks)
+	for _, l := range locks {
+		l.t.nodes[l.index].Lock()
+	}
+	defer func() {
+		for _, l := range locks {
+			l.t.nodes[l.index].Unlock()
+		}
+	}()
+
+	tx := &Tx[K, V]{m: m, slots: make(map[K]*txSlot[K, V], len(keys))}
+	for i, k := range keys {
+		s := &txSlot[K, V]{t: t, n: t.getNode(hs[i]), h: hs[i]}
+		if old != nil {
+			if n := old.getNode(hs[i]); atomic.LoadInt32(&n.moved) == 0 {
+				s.t, s.n = old, n
+			}
+		}
+		tx.slots[k] = s
+	}
+	if err = fn(tx); err != nil {
+		return nil, err
+	}
+	return tx.commit(), nil
+}
+
+func (tx *Tx[K, V]) commit() (added []*Entry[K, V]) {
+	m := tx.m
+	// a value the codec cannot encode panics before the commit starts, so
+	// that it is not applied partially
+	for _, s := range tx.slots {
+		if s.op == txSet {
+			s.enc = m.encode(s.v)
+		}
+	}
+	// nor is it if the log fails under SyncAlways: the commit goes on and
+	// panics once done
+	var failed error
+	for k, s := range tx.slots {
+		if s.op == txRead {
+			continue
+		}
+		e, err := tx.apply(k, s)
+		if e != nil {
+			added = append(added, e)
+		}
+		if failed == nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		panic(failed)
+	}
+	return
+}
+
+// apply applies the write of s to k, returning the entry it inserted if
+// any, and the error of the log if it failed to record the write.
+func (tx *Tx[K, V]) apply(k K, s *txSlot[K, V]) (added *Entry[K, V], err error) {
+	m := tx.m
+	defer func() {
+		if r := recover(); r != nil {
+			if err, _ = r.(error); !errors.Is(err, errLogFailed) {
+				panic(r)
+			}
+		}
+	}()
+	m.preserve(s.t, s.n)
+	e := m.getNodeEntry(s.t, s.n, k)
+	switch {
+	case s.op == txDel:
+		if e != nil {
+			m.remove(s.t, s.n, e)
+		}
+	case e != nil:
+		m.replace(e, s.v, 0, s.enc)
+	default:
+		if e = m.newEntry(k, s.v, s.h, 0); m.setNodeEntry(s.t, s.n, e, false, s.enc) {
+			added = e
+		}
+	}
+	return
+}
+
+func (tx *Tx[K, V]) slot(k K) *txSlot[K, V] {
+	s, ok := tx.slots[k]
+	if !ok {
+		panic(fmt.Sprintf("hashmap: key %v not declared in Txn", k))
+	}
+	return s
+}
+
+// Get returns the value of k as seen by the transaction, including its own
+// writes. It panics if k was not passed to Txn.
+func (tx *Tx[K, V]) Get(k K) (v V, ok bool) {
+	s := tx.slot(k)
+	switch s.op {
+	case txSet:
+		return s.v, true
+	case txDel:
+		return v, false
+	}
+	if e := tx.m.getNodeEntry(s.t, s.n, k); e != nil {
+		tx.m.record(s.h)
+		tx.m.touch(e)
+		return e.Value(), true
+	}
+	return v, false
+}
+
+// Set sets k to v, clearing its expiration, when the transaction commits.
+// It panics if k was not passed to Txn.
+func (tx *Tx[K, V]) Set(k K, v V) {
+	s := tx.slot(k)
+	tx.m.record(s.h)
+	s.op, s.v = txSet, v
+}
+
+// Del deletes k when the transaction commits, and reports whether k is
+// present in the transaction. It panics if k was not passed to Txn.
+func (tx *Tx[K, V]) Del(k K) bool {
+	_, ok := tx.Get(k)
+	s := tx.slot(k)
+	var zero V
+	s.op, s.v = txDel, zero
+	return ok
+}
@@ -0,0 +1,202 @@
+package hashmap
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestHashMap_Txn(t *testing.T) {
+	m := New[string, int]()
+	m.Set("a", 10)
+	m.Set("b", 0)
+	m.Set("c", 1)
+	err := m.Txn([]string{"a", "b", "c", "d"}, func(tx *Tx[string, int]) error {
+		a, _ := tx.Get("a")
+		tx.Set("a", a-3)
+		tx.Set("b", 3)
+		assertEqual(t, tx.Del("c"), true)
+		assertEqual(t, tx.Del("c"), false)
+		_, ok := tx.Get("c")
+		assertEqual(t, ok, false)
+		tx.Set("d", 4)
+		v, ok := tx.Get("d")
+		assertEqual(t, ok && v == 4, true)
+		return nil
+	})
+	assertEqual(t, err, nil)
+	for k, want := range map[string]int{"a": 7, "b": 3, "d": 4} {
+		v, ok := m.Get(k)
+		if !ok || v != want {
+			t.Fatal("commit err", k, v, ok)
+		}
+	}
+	_, ok := m.Get("c")
+	assertEqual(t, ok, false)
+	assertEqual(t, m.Size(), int64(3))
+}
+
+func TestHashMap_TxnRollback(t *testing.T) {
+	m := New[string, int]()
+	m.Set("a", 1)
+	errAbort := errors.New("abort")
+	err := m.Txn([]string{"a", "b"}, func(tx *Tx[string, int]) error {
+		tx.Set("a", 2)
+		tx.Set("b", 2)
+		return errAbort
+	})
+	assertEqual(t, err, errAbort)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic on undeclared key")
+			}
+		}()
+		m.Txn([]string{"a"}, func(tx *Tx[string, int]) error {
+			tx.Set("a", 3)
+			tx.Get("b")
+			return nil
+		})
+	}()
+
+	// neither transaction was applied and the locks were released
+	v, _ := m.Get("a")
+	assertEqual(t, v, 1)
+	_, ok := m.Get("b")
+	assertEqual(t, ok, false)
+	m.Set("a", 4)
+	v, _ = m.Get("a")
+	assertEqual(t, v, 4)
+}
+
+func TestHashMap_TxnConcurrent(t *testing.T) {
+	m, err := NewWithOptions[int, int](WithCapacity(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts, balance := 50, 100
+	for i := 0; i < accounts; i++ {
+		m.Set(i, balance)
+	}
+	all := make([]int, accounts)
+	for i := range all {
+		all[i] = i
+	}
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	// other keys come and go so that the table resizes meanwhile
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			for i := 1000; i < 20000; i++ {
+				m.Set(i, i)
+			}
+			for i := 1000; i < 20000; i++ {
+				m.Del(i)
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+	transfers := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		transfers.Add(1)
+		go func() {
+			defer transfers.Done()
+			for i := 0; i < 2000; i++ {
+				from, to := rand.IntN(accounts), rand.IntN(accounts-1)
+				if to >= from {
+					to++
+				}
+				m.Txn([]int{from, to}, func(tx *Tx[int, int]) error {
+					f, _ := tx.Get(from)
+					if f == 0 {
+						return errors.New("insufficient funds")
+					}
+					tt, _ := tx.Get(to)
+					tx.Set(from, f-1)
+					tx.Set(to, tt+1)
+					return nil
+				})
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		m.Txn(all, func(tx *Tx[int, int]) error {
+			sum := 0
+			for _, k := range all {
+				v, _ := tx.Get(k)
+				sum += v
+			}
+			if sum != accounts*balance {
+				t.Error("inconsistent total", sum)
+			}
+			return nil
+		})
+	}
+	transfers.Wait()
+	close(done)
+	wg.Wait()
+	sum := 0
+	for _, k := range all {
+		v, _ := m.Get(k)
+		sum += v
+	}
+	assertEqual(t, sum, accounts*balance)
+}
+
+func TestHashMap_TxnReaders(t *testing.T) {
+	m := New[int, int]()
+	keys := make([]int, 64)
+	for i := range keys {
+		keys[i] = i
+		m.Set(i, 0)
+	}
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			order := slices.Clone(keys)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// every commit writes the same version to all keys, so
+				// once a read sees a version later reads must see it too
+				rand.Shuffle(len(order), func(i, j int) {
+					order[i], order[j] = order[j], order[i]
+				})
+				seen := 0
+				for _, k := range order {
+					v, _ := m.Get(k)
+					if v < seen {
+						t.Errorf("read version %d of key %d after version %d", v, k, seen)
+						return
+					}
+					seen = v
+				}
+			}
+		}()
+	}
+	for version := 1; version <= 2000; version++ {
+		m.Txn(keys, func(tx *Tx[int, int]) error {
+			for _, k := range keys {
+				tx.Set(k, version)
+			}
+			return nil
+		})
+	}
+	close(done)
+	wg.Wait()
+}
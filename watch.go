@@ -0,0 +1,228 @@
+package hashmap
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultWatchBuffer = 64
+
+// Op is the kind of change reported by an Event.
+type Op int
+
+const (
+	// OpSet reports that a key was inserted or its value replaced, or that
+	// Expire changed its expiration, Old and New being its value then.
+	OpSet Op = iota + 1
+	// OpDel reports that a key was deleted, including by eviction.
+	OpDel
+	// OpLogicDel reports that a key was deleted by LogicDel.
+	OpLogicDel
+	// OpExpire reports that an expired key was reclaimed.
+	OpExpire
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpSet:
+		return "Set"
+	case OpDel:
+		return "Del"
+	case OpLogicDel:
+		return "LogicDel"
+	case OpExpire:
+		return "Expire"
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Event is a change to a key of a watched map. Old is the zero value for
+// an inserted key, and New for a deleted or expired one.
+type Event[K comparable, V any] struct {
+	Op  Op
+	Key K
+	Old V
+	New V
+}
+
+// WatchPolicy selects what happens to a watcher whose buffer is full.
+// Events are sent while the key's node is locked, so a slow subscriber is
+// never allowed to hold up writers.
+type WatchPolicy int
+
+const (
+	// WatchDrop drops the events that do not fit in the buffer and counts
+	// them in Dropped.
+	WatchDrop WatchPolicy = iota
+	// WatchClose stops the watcher and closes its channel, so that the
+	// subscriber notices it missed events and can read the map again.
+	WatchClose
+)
+
+// WithWatchBuffer sets the number of events buffered for each watcher. It
+// defaults to 64.
+func WithWatchBuffer(n int) Option {
+	return func(c *config) {
+		c.watchBuffer = n
+	}
+}
+
+// WithWatchPolicy sets what happens to a watcher whose buffer is full. It
+// defaults to WatchDrop.
+func WithWatchPolicy(p WatchPolicy) Option {
+	return func(c *config) {
+		c.watchPolicy = p
+	}
+}
+
+// Watcher receives the events of a watched key, or of the whole map, on C.
+// Events of a key arrive in the order the changes were made.
+type Watcher[K comparable, V any] struct {
+	C <-chan Event[K, V]
+
+	m       *HashMap[K, V]
+	key     K
+	all     bool
+	mu      sync.Mutex
+	c       chan Event[K, V]
+	stopped bool
+	dropped int64
+}
+
+// watchers is the immutable set of watchers of a map, replaced as a whole
+// when a watcher starts or stops.
+type watchers[K comparable, V any] struct {
+	all  []*Watcher[K, V]
+	keys map[K][]*Watcher[K, V]
+}
+
+// Watch returns a watcher receiving the changes to k. Call Stop when it is
+// no longer needed. While a map has watchers, Set and LogicDel lock nodes
+// like the other writers.
+func (m *HashMap[K, V]) Watch(k K) *Watcher[K, V] {
+	m.mustHash(k)
+	return m.watch(&Watcher[K, V]{key: k})
+}
+
+// WatchAll returns a watcher receiving the changes to every key. Call Stop
+// when it is no longer needed.
+func (m *HashMap[K, V]) WatchAll() *Watcher[K, V] {
+	return m.watch(&Watcher[K, V]{all: true})
+}
+
+func (m *HashMap[K, V]) watch(w *Watcher[K, V]) *Watcher[K, V] {
+	w.m, w.c = m, make(chan Event[K, V], m.watchBuf)
+	w.C = w.c
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	ws := m.watchers.Load().with(w, true)
+	// under the write lock, so that lock-free writers which started before
+	// the first watcher have finished
+	m.Lock()
+	m.watchers.Store(ws)
+	m.Unlock()
+	return w
+}
+
+// Stop unregisters w and closes C. Events already buffered can still be
+// received.
+func (w *Watcher[K, V]) Stop() {
+	w.unregister()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.c)
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer of w was
+// full.
+func (w *Watcher[K, V]) Dropped() int64 {
+	return atomic.LoadInt64(&w.dropped)
+}
+
+// with returns a copy of ws with w added or removed, nil if it is empty.
+func (ws *watchers[K, V]) with(w *Watcher[K, V], add bool) *watchers[K, V] {
+	next := &watchers[K, V]{keys: map[K][]*Watcher[K, V]{}}
+	if ws != nil {
+		next.all = ws.all
+		for k, l := range ws.keys {
+			next.keys[k] = l
+		}
+	}
+	if w.all {
+		next.all = toggle(next.all, w, add)
+	} else if l := toggle(next.keys[w.key], w, add); len(l) > 0 {
+		next.keys[w.key] = l
+	} else {
+		delete(next.keys, w.key)
+	}
+	if len(next.all) == 0 && len(next.keys) == 0 {
+		return nil
+	}
+	return next
+}
+
+func toggle[T comparable](l []T, x T, add bool) []T {
+	if add {
+		return append(l[:len(l):len(l)], x)
+	}
+	var out []T
+	for _, y := range l {
+		if y != x {
+			out = append(out, y)
+		}
+	}
+	return out
+}
+
+// notify records a change to the entry e in the log and sends an event to
+// the watchers of its key. enc is new encoded by encode for OpSet, nil for
+// the other ops. It is called with the node holding e locked, which orders
+// the changes to a key.
+func (m *HashMap[K, V]) notify(op Op, e *Entry[K, V], old, new V, enc []byte) {
+	if m.oplog != nil {
+		m.logOp(op, e, enc)
+	}
+	ws := m.watchers.Load()
+	if ws == nil {
+		return
+	}
+	ev := Event[K, V]{Op: op, Key: e.k, Old: old, New: new}
+	for _, w := range ws.all {
+		w.send(ev)
+	}
+	for _, w := range ws.keys[e.k] {
+		w.send(ev)
+	}
+}
+
+func (w *Watcher[K, V]) send(e Event[K, V]) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return
+	}
+	select {
+	case w.c <- e:
+		return
+	default:
+	}
+	atomic.AddInt64(&w.dropped, 1)
+	if w.m.onFull == WatchClose {
+		w.stopped = true
+		close(w.c)
+		go w.unregister()
+	}
+}
+
+// unregister removes w after its channel was closed by send, which cannot
+// take watchMu as it runs under a node lock.
+func (w *Watcher[K, V]) unregister() {
+	m := w.m
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	m.watchers.Store(m.watchers.Load().with(w, false))
+}
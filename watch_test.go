@@ -0,0 +1,136 @@
+package hashmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHashMap_Watch(t *testing.T) {
+	m := New[string, int]()
+	w := m.Watch("a")
+	m.Set("a", 1)
+	m.Set("b", 1)
+	m.Set("a", 2)
+	m.Expire("a", time.Hour)
+	m.Expire("b", time.Hour)
+	m.Del("a")
+	m.SetNX("a", 3)
+	m.SetNX("a", 4)
+	m.LogicDel("a")
+	w.Stop()
+	m.Set("a", 5)
+
+	want := []Event[string, int]{
+		{OpSet, "a", 0, 1},
+		{OpSet, "a", 1, 2},
+		{OpSet, "a", 2, 2},
+		{OpDel, "a", 2, 0},
+		{OpSet, "a", 0, 3},
+		{OpLogicDel, "a", 3, 0},
+	}
+	var got []Event[string, int]
+	for e := range w.C {
+		got = append(got, e)
+	}
+	assertEqual(t, len(got), len(want))
+	for i := range want {
+		assertEqual(t, got[i], want[i])
+	}
+	assertEqual(t, m.watchers.Load() == nil, true)
+}
+
+func TestHashMap_WatchAll(t *testing.T) {
+	m, err := NewWithOptions[string, int](WithJanitorInterval(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	w := m.WatchAll()
+	defer w.Stop()
+	m.Set("a", 1)
+	m.SetWithTTL("b", 2, 10*time.Millisecond)
+	assertEqual(t, <-w.C, Event[string, int]{OpSet, "a", 0, 1})
+	assertEqual(t, <-w.C, Event[string, int]{OpSet, "b", 0, 2})
+	select {
+	case e := <-w.C:
+		assertEqual(t, e, Event[string, int]{OpExpire, "b", 2, 0})
+	case <-time.After(5 * time.Second):
+		t.Fatal("no expire event")
+	}
+	assertEqual(t, OpExpire.String(), "Expire")
+}
+
+func TestWithWatchPolicy(t *testing.T) {
+	m, err := NewWithOptions[int, int](WithWatchBuffer(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := m.Watch(1)
+	for i := 0; i < 5; i++ {
+		m.Set(1, i)
+	}
+	assertEqual(t, len(w.C), 2)
+	assertEqual(t, w.Dropped(), int64(3))
+	w.Stop()
+	w.Stop()
+
+	m, err = NewWithOptions[int, int](WithWatchBuffer(1), WithWatchPolicy(WatchClose))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = m.WatchAll()
+	m.Set(1, 1)
+	m.Set(2, 2)
+	m.Set(3, 3)
+	assertEqual(t, (<-w.C).Key, 1)
+	if _, ok := <-w.C; ok {
+		t.Fatal("watcher not closed")
+	}
+	assertEqual(t, w.Dropped(), int64(1))
+	deadline := time.Now().Add(5 * time.Second)
+	for m.watchers.Load() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("closed watcher not unregistered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err = NewWithOptions[int, int](WithWatchBuffer(0)); err == nil {
+		t.Fatal("expected watch buffer error")
+	}
+	if _, err = NewWithOptions[int, int](WithWatchPolicy(WatchPolicy(9))); err == nil {
+		t.Fatal("expected watch policy error")
+	}
+}
+
+func TestHashMap_WatchOrder(t *testing.T) {
+	writers, sets := 8, 1000
+	m, err := NewWithOptions[int, int](WithWatchBuffer(writers * sets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := m.Watch(0)
+	wg := sync.WaitGroup{}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 1; j <= sets; j++ {
+				m.Set(0, i*sets+j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	w.Stop()
+	prev, n := 0, 0
+	for e := range w.C {
+		if e.Old != prev {
+			t.Fatalf("event %d replaced %d, previous event set %d", n, e.Old, prev)
+		}
+		prev = e.New
+		n++
+	}
+	assertEqual(t, n, writers*sets)
+	assertEqual(t, w.Dropped(), int64(0))
+}